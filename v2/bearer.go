@@ -0,0 +1,316 @@
+package indieauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCachedTokens is the most verification results a TokenVerifier keeps. When
+// full, expired results are removed, then if still full others are removed at
+// random.
+const maxCachedTokens = 1024
+
+// invalidTokenTTL is the longest an invalid token result is cached for, so that
+// requests with made-up tokens don't fill the cache with long lived results.
+const invalidTokenTTL = 5 * time.Second
+
+type contextKey struct{}
+
+// ContextWithResponse returns a copy of ctx that carries response.
+func ContextWithResponse(ctx context.Context, response *Response) context.Context {
+	return context.WithValue(ctx, contextKey{}, response)
+}
+
+// ResponseFromContext returns the Response stored in ctx, if there is one.
+func ResponseFromContext(ctx context.Context) (*Response, bool) {
+	response, ok := ctx.Value(contextKey{}).(*Response)
+	return response, ok && response != nil
+}
+
+// TokenVerifier checks the access tokens sent to a resource server, such as a
+// micropub endpoint, with the token endpoint that issued them.
+type TokenVerifier struct {
+	// Realm, if set, is included in the WWW-Authenticate header of responses.
+	Realm string
+
+	// Scopes, if set, are required for a request to be allowed. A token that
+	// does not have any of them is rejected with an insufficient_scope error.
+	Scopes []string
+
+	// Policy, if set, is used to find the endpoint to check tokens with from
+	// the profile URL returned by Claim, instead of using the endpoints given
+	// to NewTokenVerifier. See VerifyClaim.
+	Policy *IssuerPolicy
+
+	// Claim returns the profile URL that a request is for, for example by
+	// looking up the host or path of the request. It must be set when Policy
+	// is set. If it returns an error the request is rejected with an
+	// invalid_request error.
+	Claim func(*http.Request) (string, error)
+
+	config    *Config
+	endpoints Endpoints
+	ttl       time.Duration
+
+	mu    sync.Mutex
+	cache map[[sha256.Size]byte]verifiedToken
+}
+
+type verifiedToken struct {
+	response *Response
+	err      *OAuthError
+	expiry   time.Time
+}
+
+// NewTokenVerifier creates a TokenVerifier that checks tokens with
+// Config.Introspect using endpoints. The result of checking a token is cached
+// for ttl, or until the token expires if that is sooner. Invalid tokens are
+// cached for at most 5 seconds. If ttl is zero results are not cached.
+func NewTokenVerifier(config *Config, endpoints Endpoints, ttl time.Duration) *TokenVerifier {
+	return &TokenVerifier{
+		config:    config,
+		endpoints: endpoints,
+		ttl:       ttl,
+		cache:     map[[sha256.Size]byte]verifiedToken{},
+	}
+}
+
+// Verify checks token and returns a Response describing it. If the token is not
+// valid an *OAuthError with the code ErrInvalidToken is returned, any other
+// error means the token could not be checked.
+//
+// If the TokenVerifier has a Policy use VerifyClaim instead.
+func (v *TokenVerifier) Verify(ctx context.Context, token string) (*Response, error) {
+	return v.cached(token, "", func() (*Response, error) {
+		return v.introspect(ctx, v.endpoints, token)
+	})
+}
+
+// cached returns the cached result for token and me, or calls fn and caches its
+// result.
+func (v *TokenVerifier) cached(token, me string, fn func() (*Response, error)) (*Response, error) {
+	key := sha256.Sum256([]byte(me + " " + token))
+	now := time.Now()
+
+	v.mu.Lock()
+	cached, ok := v.cache[key]
+	v.mu.Unlock()
+
+	if !ok || !now.Before(cached.expiry) {
+		response, err := fn()
+		if err != nil {
+			oauthErr, ok := err.(*OAuthError)
+			if !ok {
+				return nil, err
+			}
+
+			ttl := v.ttl
+			if ttl > invalidTokenTTL {
+				ttl = invalidTokenTTL
+			}
+
+			cached = verifiedToken{err: oauthErr, expiry: now.Add(ttl)}
+		} else {
+			cached = verifiedToken{response: response, expiry: now.Add(v.ttl)}
+			if !response.Expiry.IsZero() && response.Expiry.Before(cached.expiry) {
+				cached.expiry = response.Expiry
+			}
+		}
+
+		v.store(key, cached, now)
+	}
+
+	if cached.err != nil {
+		return nil, cached.err
+	}
+	if !cached.response.Expiry.IsZero() && !now.Before(cached.response.Expiry) {
+		return nil, &OAuthError{Code: ErrInvalidToken, Description: "the access token has expired"}
+	}
+
+	return cached.response, nil
+}
+
+// introspect checks token with endpoints. Only an invalid token results in an
+// *OAuthError being returned.
+func (v *TokenVerifier) introspect(ctx context.Context, endpoints Endpoints, token string) (*Response, error) {
+	introspection, err := v.config.IntrospectContext(ctx, endpoints, token)
+	if err != nil {
+		return nil, fmt.Errorf("token introspection failed: %w", err)
+	}
+
+	if !introspection.Active {
+		return nil, &OAuthError{Code: ErrInvalidToken, Description: "the access token is not valid"}
+	}
+
+	return &Response{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		Scopes:      introspection.Scopes,
+		Me:          introspection.Me,
+		Expiry:      introspection.Expiry,
+	}, nil
+}
+
+func (v *TokenVerifier) store(key [sha256.Size]byte, result verifiedToken, now time.Time) {
+	if v.ttl <= 0 {
+		return
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if _, ok := v.cache[key]; !ok && len(v.cache) >= maxCachedTokens {
+		for k, cached := range v.cache {
+			if !now.Before(cached.expiry) {
+				delete(v.cache, k)
+			}
+		}
+
+		// Map iteration order is random, so this removes a random result.
+		for k := range v.cache {
+			if len(v.cache) < maxCachedTokens {
+				break
+			}
+			delete(v.cache, k)
+		}
+	}
+
+	v.cache[key] = result
+}
+
+// Handler returns a handler that only calls next for requests that have a
+// valid access token, in either the Authorization header or the access_token
+// form field. The Response for the token can be retrieved in next using
+// ResponseFromContext. Other requests are answered with an error as described
+// in RFC 6750.
+func (v *TokenVerifier) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := bearerToken(r)
+		if err != nil {
+			writeBearerError(w, v.Realm, err.(*OAuthError), nil)
+			return
+		}
+		if token == "" {
+			writeBearerError(w, v.Realm, nil, nil)
+			return
+		}
+
+		var response *Response
+		if v.Policy != nil {
+			if v.Claim == nil {
+				http.Error(w, "no claim configured for issuer policy", http.StatusInternalServerError)
+				return
+			}
+
+			me, err := v.Claim(r)
+			if err != nil {
+				writeBearerError(w, v.Realm, &OAuthError{
+					Code:        ErrInvalidRequest,
+					Description: err.Error(),
+				}, nil)
+				return
+			}
+
+			response, err = v.VerifyClaim(r.Context(), token, me)
+		} else {
+			response, err = v.Verify(r.Context(), token)
+		}
+		if err != nil {
+			if oauthErr, ok := err.(*OAuthError); ok && oauthErr.Code == ErrInvalidToken {
+				writeBearerError(w, v.Realm, oauthErr, nil)
+				return
+			}
+
+			http.Error(w, "could not verify access token", http.StatusServiceUnavailable)
+			return
+		}
+
+		if len(v.Scopes) > 0 && !hasAnyScope(response, v.Scopes) {
+			writeBearerError(w, v.Realm, &OAuthError{
+				Code:        ErrInsufficientScope,
+				Description: fmt.Sprintf("the access token requires one of the scopes: %s", strings.Join(v.Scopes, " ")),
+			}, v.Scopes)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithResponse(r.Context(), response)))
+	})
+}
+
+// bearerToken returns the access token sent with r, or an empty string if there
+// is not one. If a token is sent in more than one way an *OAuthError is
+// returned.
+func bearerToken(r *http.Request) (string, error) {
+	var header string
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+			header = strings.TrimSpace(auth[7:])
+		}
+	}
+
+	form := r.PostFormValue("access_token")
+
+	if header != "" && form != "" {
+		return "", &OAuthError{
+			Code:        ErrInvalidRequest,
+			Description: "the access token must only be sent once",
+		}
+	}
+
+	if header != "" {
+		return header, nil
+	}
+
+	return form, nil
+}
+
+func hasAnyScope(response *Response, scopes []string) bool {
+	for _, scope := range scopes {
+		if response.HasScope(scope) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeBearerError responds with the WWW-Authenticate header and status code
+// for err, as in https://tools.ietf.org/html/rfc6750#section-3. If err is nil
+// the request had no authentication, so only the challenge is sent.
+func writeBearerError(w http.ResponseWriter, realm string, err *OAuthError, scopes []string) {
+	var params []string
+	if realm != "" {
+		params = append(params, fmt.Sprintf("realm=%q", realm))
+	}
+
+	status := http.StatusUnauthorized
+	if err != nil {
+		params = append(params, fmt.Sprintf("error=%q", string(err.Code)))
+		if err.Description != "" {
+			params = append(params, fmt.Sprintf("error_description=%q", err.Description))
+		}
+		if len(scopes) > 0 {
+			params = append(params, fmt.Sprintf("scope=%q", strings.Join(scopes, " ")))
+		}
+
+		switch err.Code {
+		case ErrInvalidRequest:
+			status = http.StatusBadRequest
+		case ErrInsufficientScope:
+			status = http.StatusForbidden
+		}
+	}
+
+	challenge := "Bearer"
+	if len(params) > 0 {
+		challenge += " " + strings.Join(params, ", ")
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, http.StatusText(status), status)
+}
@@ -0,0 +1,232 @@
+package indieauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func testIntrospectionServer(requests *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(requests, 1)
+		w.Header().Set("Content-Type", "application/json")
+
+		if r.FormValue("token") != "tokentoken" {
+			fmt.Fprint(w, `{"active": false}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"active": true, "me": "https://me.example.com/", "scope": "create update"}`)
+	}))
+}
+
+func TestTokenVerifierHandler(t *testing.T) {
+	var requests int32
+	introspection := testIntrospectionServer(&requests)
+	defer introspection.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{
+		Introspection: urlParse(introspection.URL),
+	}, time.Minute)
+	verifier.Realm = "micropub"
+
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := ResponseFromContext(r.Context())
+		if !ok {
+			http.Error(w, "", http.StatusInternalServerError)
+			return
+		}
+
+		fmt.Fprint(w, response.Me)
+	}))
+
+	testCases := map[string]struct {
+		header       string
+		body         string
+		status       int
+		authenticate string
+		responseBody string
+	}{
+		"header": {
+			header:       "Bearer tokentoken",
+			status:       http.StatusOK,
+			responseBody: "https://me.example.com/",
+		},
+		"form": {
+			body:         "access_token=tokentoken",
+			status:       http.StatusOK,
+			responseBody: "https://me.example.com/",
+		},
+		"missing": {
+			status:       http.StatusUnauthorized,
+			authenticate: `Bearer realm="micropub"`,
+		},
+		"invalid": {
+			header:       "Bearer what",
+			status:       http.StatusUnauthorized,
+			authenticate: `Bearer realm="micropub", error="invalid_token", error_description="the access token is not valid"`,
+		},
+		"both": {
+			header:       "Bearer tokentoken",
+			body:         "access_token=tokentoken",
+			status:       http.StatusBadRequest,
+			authenticate: `Bearer realm="micropub", error="invalid_request", error_description="the access token must only be sent once"`,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.header != "" {
+				r.Header.Set("Authorization", tc.header)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert(w.Code).Equal(tc.status)
+			assert(w.Header().Get("WWW-Authenticate")).Equal(tc.authenticate)
+			if tc.responseBody != "" {
+				assert(w.Body.String()).Equal(tc.responseBody)
+			}
+		})
+	}
+}
+
+func TestTokenVerifierHandlerInsufficientScope(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var requests int32
+	introspection := testIntrospectionServer(&requests)
+	defer introspection.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{
+		Introspection: urlParse(introspection.URL),
+	}, time.Minute)
+	verifier.Scopes = []string{"delete"}
+
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer tokentoken")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert(w.Code).Equal(http.StatusForbidden)
+	assert(w.Header().Get("WWW-Authenticate")).Equal(`Bearer error="insufficient_scope", error_description="the access token requires one of the scopes: delete", scope="delete"`)
+}
+
+func TestTokenVerifierHandlerWhenEndpointFails(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	introspection := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusInternalServerError)
+	}))
+	defer introspection.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{
+		Introspection: urlParse(introspection.URL),
+	}, time.Minute)
+
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Authorization", "Bearer tokentoken")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert(w.Code).Equal(http.StatusServiceUnavailable)
+	assert(w.Header().Get("WWW-Authenticate")).Equal("")
+}
+
+func TestTokenVerifierCachesResults(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var requests int32
+	introspection := testIntrospectionServer(&requests)
+	defer introspection.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{
+		Introspection: urlParse(introspection.URL),
+	}, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		response, err := verifier.Verify(context.Background(), "tokentoken")
+		assert(err).Must.Nil()
+		assert(response.Me).Equal("https://me.example.com/")
+		assert(response.Scopes).Equal([]string{"create", "update"})
+
+		_, err = verifier.Verify(context.Background(), "what")
+		assert(errors.Is(err, ErrInvalidToken)).True()
+	}
+
+	assert(atomic.LoadInt32(&requests)).Equal(int32(2))
+}
+
+func TestTokenVerifierWithoutTTL(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var requests int32
+	introspection := testIntrospectionServer(&requests)
+	defer introspection.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{
+		Introspection: urlParse(introspection.URL),
+	}, 0)
+
+	for i := 0; i < 3; i++ {
+		_, err := verifier.Verify(context.Background(), "tokentoken")
+		assert(err).Nil()
+	}
+
+	assert(atomic.LoadInt32(&requests)).Equal(int32(3))
+}
+
+func TestTokenVerifierCacheIsBounded(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Hour)
+	now := time.Now()
+
+	for i := 0; i < maxCachedTokens+100; i++ {
+		verifier.store(sha256.Sum256([]byte(fmt.Sprint(i))), verifiedToken{
+			err:    &OAuthError{Code: ErrInvalidToken},
+			expiry: now.Add(time.Hour),
+		}, now)
+	}
+
+	assert(verifier.cache).Len(maxCachedTokens)
+}
+
+func TestTokenVerifierCachesInvalidTokensBriefly(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var requests int32
+	introspection := testIntrospectionServer(&requests)
+	defer introspection.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{
+		Introspection: urlParse(introspection.URL),
+	}, time.Hour)
+
+	_, err := verifier.Verify(context.Background(), "what")
+	assert(errors.Is(err, ErrInvalidToken)).True()
+
+	for _, cached := range verifier.cache {
+		assert(cached.expiry.Before(time.Now().Add(invalidTokenTTL + time.Second))).True()
+	}
+}
@@ -0,0 +1,277 @@
+package indieauth
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A DiscoveryCache stores the responses fetched when discovering endpoints, so
+// that the profile page and metadata document for a user are not requested
+// every time they sign-in.
+type DiscoveryCache interface {
+	// Get returns the entry stored for url, if one exists.
+	Get(url string) (*CacheEntry, bool)
+
+	// Set stores the entry for url, replacing any existing entry.
+	Set(url string, entry *CacheEntry)
+}
+
+// CacheEntry is a response stored in a DiscoveryCache.
+type CacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// Expires is the time after which the entry must be revalidated before
+	// being used.
+	Expires time.Time
+}
+
+// Fresh returns true if the entry can be used without revalidating it.
+func (e *CacheEntry) Fresh(now time.Time) bool {
+	return now.Before(e.Expires)
+}
+
+func (e *CacheEntry) hasValidator() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+func (e *CacheEntry) response(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     http.StatusText(e.StatusCode),
+		StatusCode: e.StatusCode,
+		Header:     e.Header,
+		Body:       ioutil.NopCloser(bytes.NewReader(e.Body)),
+		Request:    req,
+	}
+}
+
+// DefaultMaxCacheEntries is the number of entries a MemoryCache keeps if
+// MaxEntries is not set.
+const DefaultMaxCacheEntries = 1000
+
+// MemoryCache is a DiscoveryCache that keeps entries in memory. When it is full
+// the least recently used entry is removed.
+type MemoryCache struct {
+	// MaxEntries is the number of entries to keep, if zero then
+	// DefaultMaxCacheEntries is used. It must not be changed once the cache is
+	// in use.
+	MaxEntries int
+
+	mu      sync.Mutex
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type memoryCacheItem struct {
+	url   string
+	entry *CacheEntry
+}
+
+// NewMemoryCache returns an empty MemoryCache.
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{}
+}
+
+// Get returns the entry stored for url, if one exists. Entries that are no
+// longer fresh, and can't be revalidated, are removed.
+func (c *MemoryCache) Get(url string) (*CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[url]
+	if !ok {
+		return nil, false
+	}
+
+	entry := elem.Value.(*memoryCacheItem).entry
+	if !entry.Fresh(time.Now()) && !entry.hasValidator() {
+		c.remove(elem)
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry, true
+}
+
+// Set stores the entry for url, replacing any existing entry.
+func (c *MemoryCache) Set(url string, entry *CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.order = list.New()
+		c.entries = map[string]*list.Element{}
+	}
+
+	if elem, ok := c.entries[url]; ok {
+		elem.Value.(*memoryCacheItem).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[url] = c.order.PushFront(&memoryCacheItem{url: url, entry: entry})
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxCacheEntries
+	}
+
+	for c.order.Len() > maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *MemoryCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*memoryCacheItem).url)
+}
+
+// getOnce performs a GET request for url, using the configured Cache if there
+// is one. Only successful responses are cached, and only when the headers of
+// the response allow it. Reading more than limit bytes of the response body
+// will return a *BodyTooLargeError.
+func (c *Config) getOnce(ctx context.Context, client *http.Client, url string, limit int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Cache == nil {
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = limitBody(resp.Body, limit, url)
+
+		return resp, nil
+	}
+
+	now := time.Now()
+
+	entry, ok := c.Cache.Get(url)
+	if ok {
+		if entry.Fresh(now) {
+			return entry.response(req), nil
+		}
+
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = limitBody(resp.Body, limit, url)
+
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+
+		expires, _ := cacheExpiry(resp.Header, now)
+		revalidated := &CacheEntry{
+			StatusCode: entry.StatusCode,
+			Header:     entry.Header,
+			Body:       entry.Body,
+			Expires:    expires,
+		}
+		c.Cache.Set(url, revalidated)
+
+		return revalidated.response(req), nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	expires, storable := cacheExpiry(resp.Header, now)
+	if !storable {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = &CacheEntry{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		Expires:    expires,
+	}
+	c.Cache.Set(url, entry)
+
+	return entry.response(req), nil
+}
+
+// cacheExpiry returns the time a response with the given headers stops being
+// fresh, and whether the response may be stored at all. A response that has a
+// validator can be stored even if it is never fresh, so that it can be
+// revalidated.
+func cacheExpiry(header http.Header, now time.Time) (expires time.Time, storable bool) {
+	hasValidator := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+
+	var noStore, noCache, hasMaxAge bool
+	var maxAge int
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value := directive, ""
+		if i := strings.Index(directive, "="); i >= 0 {
+			name, value = directive[:i], strings.Trim(directive[i+1:], `" `)
+		}
+
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "no-store", "private":
+			noStore = true
+		case "no-cache":
+			noCache = true
+		case "max-age":
+			if !hasMaxAge {
+				hasMaxAge = true
+				maxAge, _ = strconv.Atoi(value)
+			}
+		}
+	}
+
+	// The directives are all read first so that their order doesn't matter.
+	switch {
+	case noStore:
+		return now, false
+	case noCache:
+		return now, hasValidator
+	case hasMaxAge:
+		if maxAge <= 0 {
+			return now, hasValidator
+		}
+		return now.Add(time.Duration(maxAge) * time.Second), true
+	}
+
+	if expiresHeader := header.Get("Expires"); expiresHeader != "" {
+		expiresAt, err := http.ParseTime(expiresHeader)
+		if err != nil {
+			return now, hasValidator
+		}
+
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			expiresAt = now.Add(expiresAt.Sub(date))
+		}
+
+		if expiresAt.After(now) {
+			return expiresAt, true
+		}
+	}
+
+	return now, hasValidator
+}
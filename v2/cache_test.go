@@ -0,0 +1,232 @@
+package indieauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestFindEndpointsCacheMaxAge(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var metadataRequests, homepageRequests int
+
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metadataRequests++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		fmt.Fprint(w, `{"authorization_endpoint": "http://example.com/auth"}`)
+	}))
+	defer metadata.Close()
+
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		homepageRequests++
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "max-age=60")
+		fmt.Fprintf(w, `<link rel="indieauth-metadata" href="%s" />`, metadata.URL)
+	}))
+	defer homepage.Close()
+
+	config := &Config{Cache: NewMemoryCache()}
+
+	for i := 0; i < 3; i++ {
+		endpoints, err := config.FindEndpoints(homepage.URL)
+		assert(err).Must.Nil()
+		assert(endpoints.Authorization.String()).Equal("http://example.com/auth")
+	}
+
+	assert(homepageRequests).Equal(1)
+	assert(metadataRequests).Equal(1)
+}
+
+func TestFindEndpointsCacheETag(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var fullRequests, conditionalRequests int
+
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditionalRequests++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		fullRequests++
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<link rel="authorization_endpoint" href="http://example.com/auth" />`)
+	}))
+	defer homepage.Close()
+
+	config := &Config{Cache: NewMemoryCache()}
+
+	for i := 0; i < 3; i++ {
+		endpoints, err := config.FindEndpoints(homepage.URL)
+		assert(err).Must.Nil()
+		assert(endpoints.Authorization.String()).Equal("http://example.com/auth")
+	}
+
+	assert(fullRequests).Equal(1)
+	assert(conditionalRequests).Equal(2)
+}
+
+func TestFindEndpointsCacheNoStore(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var requests int
+
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `<link rel="authorization_endpoint" href="http://example.com/auth" />`)
+	}))
+	defer homepage.Close()
+
+	config := &Config{Cache: NewMemoryCache()}
+
+	for i := 0; i < 2; i++ {
+		_, err := config.FindEndpoints(homepage.URL)
+		assert(err).Must.Nil()
+	}
+
+	assert(requests).Equal(2)
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := map[string]struct {
+		header   http.Header
+		expires  time.Time
+		storable bool
+	}{
+		"none": {
+			header:   http.Header{},
+			expires:  now,
+			storable: false,
+		},
+		"max-age": {
+			header:   http.Header{"Cache-Control": {"public, max-age=60"}},
+			expires:  now.Add(time.Minute),
+			storable: true,
+		},
+		"max-age takes precedence": {
+			header: http.Header{
+				"Cache-Control": {"max-age=60"},
+				"Expires":       {"Wed, 01 Jan 2020 14:00:00 GMT"},
+			},
+			expires:  now.Add(time.Minute),
+			storable: true,
+		},
+		"expires": {
+			header: http.Header{
+				"Date":    {"Wed, 01 Jan 2020 12:00:00 GMT"},
+				"Expires": {"Wed, 01 Jan 2020 13:00:00 GMT"},
+			},
+			expires:  now.Add(time.Hour),
+			storable: true,
+		},
+		"expired with validator": {
+			header: http.Header{
+				"Expires": {"0"},
+				"Etag":    {`"abc"`},
+			},
+			expires:  now,
+			storable: true,
+		},
+		"no-store": {
+			header:   http.Header{"Cache-Control": {"no-store, max-age=60"}},
+			expires:  now,
+			storable: false,
+		},
+		"no-store after max-age": {
+			header:   http.Header{"Cache-Control": {"max-age=60, no-store"}},
+			expires:  now,
+			storable: false,
+		},
+		"private after max-age": {
+			header:   http.Header{"Cache-Control": {"max-age=60, private"}},
+			expires:  now,
+			storable: false,
+		},
+		"no-cache after max-age": {
+			header:   http.Header{"Cache-Control": {"max-age=60, no-cache"}},
+			expires:  now,
+			storable: false,
+		},
+		"no-cache after max-age with validator": {
+			header: http.Header{
+				"Cache-Control": {"max-age=60, no-cache"},
+				"Etag":          {`"abc"`},
+			},
+			expires:  now,
+			storable: true,
+		},
+		"no-store after no-cache": {
+			header: http.Header{
+				"Cache-Control": {"no-cache, no-store"},
+				"Etag":          {`"abc"`},
+			},
+			expires:  now,
+			storable: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			expires, storable := cacheExpiry(tc.header, now)
+			assert(expires.Equal(tc.expires)).True()
+			assert(storable).Equal(tc.storable)
+		})
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	cache := NewMemoryCache()
+	cache.MaxEntries = 2
+
+	expires := time.Now().Add(time.Hour)
+	cache.Set("a", &CacheEntry{Expires: expires})
+	cache.Set("b", &CacheEntry{Expires: expires})
+
+	_, ok := cache.Get("a")
+	assert(ok).True()
+
+	cache.Set("c", &CacheEntry{Expires: expires})
+
+	_, ok = cache.Get("a")
+	assert(ok).True()
+	_, ok = cache.Get("b")
+	assert(ok).False()
+	_, ok = cache.Get("c")
+	assert(ok).True()
+}
+
+func TestMemoryCacheDropsStaleEntriesWithoutValidator(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	cache := NewMemoryCache()
+
+	expired := time.Now().Add(-time.Second)
+	cache.Set("stale", &CacheEntry{Header: http.Header{}, Expires: expired})
+	cache.Set("validated", &CacheEntry{Header: http.Header{"Etag": {`"abc"`}}, Expires: expired})
+
+	_, ok := cache.Get("stale")
+	assert(ok).False()
+	_, ok = cache.Get("validated")
+	assert(ok).True()
+
+	assert(cache.entries).Len(1)
+}
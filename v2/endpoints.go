@@ -1,42 +1,182 @@
 package indieauth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"mime"
 	"net/http"
 	"net/url"
+	"strings"
 
 	"github.com/tomnomnom/linkheader"
-	"golang.org/x/net/html"
 )
 
+// Endpoints contains the endpoints discovered for a user's profile URL.
 type Endpoints struct {
 	Authorization *url.URL
 	Token         *url.URL
+
+	// Micropub, Microsub, Webmention and Media are the other IndieWeb
+	// endpoints linked from the profile URL, they will be nil if not found.
+	Micropub   *url.URL
+	Microsub   *url.URL
+	Webmention *url.URL
+	Media      *url.URL
+
+	// Me is the canonical profile URL. It will differ from the URL discovery
+	// was started with if a permanent redirect was followed.
+	Me string
+
+	// Redirects lists each redirect followed when fetching the profile URL.
+	Redirects []Redirect
+
+	// Revocation, Introspection and Userinfo are the token revocation, token
+	// introspection and userinfo endpoints, they will only be set when
+	// discovered using a metadata document that defines them.
+	Revocation    *url.URL
+	Introspection *url.URL
+	Userinfo      *url.URL
+
+	// Metadata is the server metadata document, it will only be set when the
+	// endpoints were discovered using a "indieauth-metadata" link.
+	Metadata *Metadata
+}
+
+// Issuer returns the issuer identifier from the server metadata, or an empty
+// string if the endpoints were not discovered using a metadata document. An
+// issuer that is not a prefix of the metadata URL is ignored during discovery,
+// as the document could otherwise claim to be for any server.
+func (e Endpoints) Issuer() string {
+	if e.Metadata == nil {
+		return ""
+	}
+
+	return e.Metadata.Issuer
+}
+
+// VerifyIssuer checks that the "iss" parameter returned to the RedirectURL
+// matches the issuer that was discovered. If no issuer was discovered then any
+// value is accepted.
+func (e Endpoints) VerifyIssuer(iss string) error {
+	return verifyIssuer(e.Issuer(), iss)
+}
+
+func verifyIssuer(expected, iss string) error {
+	if expected == "" {
+		return nil
+	}
+
+	if iss == "" {
+		return ErrIssuerMissing
+	}
+
+	if iss != expected {
+		return ErrIssuerMismatch
+	}
+
+	return nil
+}
+
+// Metadata is the IndieAuth server metadata document.
+//
+// See https://indieauth.spec.indieweb.org/#indieauth-server-metadata
+type Metadata struct {
+	Issuer                                     string   `json:"issuer"`
+	AuthorizationEndpoint                      string   `json:"authorization_endpoint"`
+	TokenEndpoint                              string   `json:"token_endpoint"`
+	IntrospectionEndpoint                      string   `json:"introspection_endpoint,omitempty"`
+	IntrospectionEndpointAuthMethodsSupported  []string `json:"introspection_endpoint_auth_methods_supported,omitempty"`
+	RevocationEndpoint                         string   `json:"revocation_endpoint,omitempty"`
+	RevocationEndpointAuthMethodsSupported     []string `json:"revocation_endpoint_auth_methods_supported,omitempty"`
+	ScopesSupported                            []string `json:"scopes_supported,omitempty"`
+	ResponseTypesSupported                     []string `json:"response_types_supported,omitempty"`
+	GrantTypesSupported                        []string `json:"grant_types_supported,omitempty"`
+	ServiceDocumentation                       string   `json:"service_documentation,omitempty"`
+	CodeChallengeMethodsSupported              []string `json:"code_challenge_methods_supported,omitempty"`
+	AuthorizationResponseIssParameterSupported bool     `json:"authorization_response_iss_parameter_supported,omitempty"`
+	UserinfoEndpoint                           string   `json:"userinfo_endpoint,omitempty"`
+}
+
+// SupportsScope returns true if the server lists scope as supported.
+func (m *Metadata) SupportsScope(scope string) bool {
+	return contains(m.ScopesSupported, scope)
+}
+
+// SupportsCodeChallengeMethod returns true if the server lists method as a
+// supported code challenge method.
+func (m *Metadata) SupportsCodeChallengeMethod(method string) bool {
+	return contains(m.CodeChallengeMethodsSupported, method)
 }
 
-// FindEndpoints retrieves the defined authorization and token endpoints for
-// 'me'. As an authorization endpoint must exist to authenticate a user
+func contains(list []string, s string) bool {
+	for _, candidate := range list {
+		if candidate == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// FindEndpoints retrieves the defined authorization and token endpoints, along
+// with any micropub, microsub, webmention or media endpoints, for 'me'. As an
+// authorization endpoint must exist to authenticate a user
 // ErrAuthorizationEndpointMissing will be returned if one cannot be found.
+//
+// The value of 'me' is parsed with ParseProfileURL, if it has no scheme then
+// https is tried before http. Redirects are followed, with permanent redirects
+// changing the canonical profile URL that is returned as Me.
 func (c *Config) FindEndpoints(me string) (Endpoints, error) {
-	var endpoints Endpoints
+	return c.FindEndpointsContext(context.Background(), me)
+}
+
+// FindEndpointsContext is like FindEndpoints but uses ctx for the requests
+// made.
+//
+// Concurrent calls for the same profile URL share a single discovery, so the
+// Endpoints returned may be shared and must not be modified.
+func (c *Config) FindEndpointsContext(ctx context.Context, me string) (Endpoints, error) {
+	meURL, schemeless, err := parseProfileURL(me)
+	if err != nil {
+		return Endpoints{}, err
+	}
 
-	client := http.DefaultClient
-	if c.Client != nil {
-		client = c.Client
+	key := meURL.String()
+	if schemeless {
+		key = meURL.Host + meURL.RequestURI()
 	}
 
-	meURL, err := url.Parse(me)
+	return c.discoveries.do(ctx, key, func() (Endpoints, error) {
+		return c.findEndpoints(ctx, me, nil)
+	})
+}
+
+func (c *Config) findEndpoints(ctx context.Context, me string, report *DiscoveryReport) (Endpoints, error) {
+	var endpoints Endpoints
+
+	client := c.client()
+
+	meURL, schemeless, err := parseProfileURL(me)
 	if err != nil {
 		return endpoints, err
 	}
 
-	resp, err := client.Get(meURL.String())
+	profileCtx, cancel := withTimeout(ctx, c.ProfileTimeout)
+	defer cancel()
+
+	resp, redirects, err := c.get(profileCtx, client, meURL.String(), c.maxProfileSize(), report)
+	if err != nil && schemeless && profileCtx.Err() == nil {
+		meURL.Scheme = "http"
+		resp, redirects, err = c.get(profileCtx, client, meURL.String(), c.maxProfileSize(), report)
+	}
 	if err != nil {
 		return endpoints, err
 	}
 	defer resp.Body.Close()
 
+	finalURL := resp.Request.URL
+
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return endpoints, &RequestError{
 			StatusCode: resp.StatusCode,
@@ -44,31 +184,68 @@ func (c *Config) FindEndpoints(me string) (Endpoints, error) {
 	}
 
 	links := linkheader.ParseMultiple(resp.Header["Link"])
+	headerLinks := len(links)
 
 	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	if mediatype == "text/html" {
-		root, err := html.Parse(resp.Body)
-		if err == nil {
-			links = append(links, findLinks(root)...)
+		links, err = findLinks(resp.Body, links)
+		report.foundHTMLLinks(links[headerLinks:])
+
+		var tooLarge *BodyTooLargeError
+		if errors.As(err, &tooLarge) {
+			return endpoints, err
+		}
+	}
+
+	source := func(i int) string {
+		if i < headerLinks {
+			return SourceLinkHeader
 		}
+		return SourceHTML
 	}
 
-	metadataEndpoints := links.FilterByRel("indieauth-metadata")
-	if len(metadataEndpoints) != 0 {
-		linkURL, err := meURL.Parse(metadataEndpoints[0].URL)
+	var metadataURL *url.URL
+	for i, link := range links {
+		if link.Rel != "indieauth-metadata" {
+			continue
+		}
+
+		if metadataURL != nil {
+			report.reject(link.Rel, link.URL, source(i), "an earlier indieauth-metadata link is used")
+			continue
+		}
+
+		metadataURL, err = finalURL.Parse(link.URL)
 		if err != nil {
+			report.reject(link.Rel, link.URL, source(i), "not a valid URL")
 			return endpoints, err
 		}
+		report.accept(link.Rel, link.URL, source(i), "first indieauth-metadata link")
+	}
+
+	if metadataURL != nil {
+		for i, link := range links {
+			if link.Rel == "authorization_endpoint" || link.Rel == "token_endpoint" {
+				report.reject(link.Rel, link.URL, source(i), "indieauth-metadata takes precedence")
+			}
+		}
 
-		endpoints, err = c.findByDiscoveryEndpoint(client, linkURL)
+		endpoints, err = c.findByDiscoveryEndpoint(ctx, client, metadataURL, report)
 	} else {
-		endpoints, err = c.findDirectly(meURL, links)
+		endpoints, err = c.findDirectly(finalURL, links, source, report)
 	}
 
 	if err != nil {
 		return endpoints, err
 	}
 
+	endpoints.Me = canonicalURL(meURL.String(), redirects)
+	if canonical, err := ParseProfileURL(endpoints.Me); err == nil {
+		endpoints.Me = canonical.String()
+	}
+	endpoints.Redirects = redirects
+	findOthers(finalURL, links, &endpoints)
+
 	if endpoints.Authorization == nil {
 		return endpoints, ErrAuthorizationEndpointMissing
 	}
@@ -76,15 +253,13 @@ func (c *Config) FindEndpoints(me string) (Endpoints, error) {
 	return endpoints, nil
 }
 
-type metadata struct {
-	AuthorizationEndpoint string `json:"authorization_endpoint"`
-	TokenEndpoint         string `json:"token_endpoint"`
-}
-
-func (c *Config) findByDiscoveryEndpoint(client *http.Client, url *url.URL) (Endpoints, error) {
+func (c *Config) findByDiscoveryEndpoint(ctx context.Context, client *http.Client, metadataURL *url.URL, report *DiscoveryReport) (Endpoints, error) {
 	var endpoints Endpoints
 
-	resp, err := client.Get(url.String())
+	ctx, cancel := withTimeout(ctx, c.MetadataTimeout)
+	defer cancel()
+
+	resp, _, err := c.get(ctx, client, metadataURL.String(), c.maxMetadataSize(), report)
 	if err != nil {
 		return endpoints, err
 	}
@@ -96,46 +271,143 @@ func (c *Config) findByDiscoveryEndpoint(client *http.Client, url *url.URL) (End
 		}
 	}
 
-	var v metadata
+	var v Metadata
 	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
 		return endpoints, err
 	}
+	if v.Issuer != "" && !validIssuer(metadataURL, v.Issuer) {
+		report.reject("issuer", v.Issuer, SourceMetadata, "not a prefix of the metadata URL")
+		v.Issuer = ""
+	}
+	endpoints.Metadata = &v
+	report.usedMetadata()
 
-	linkURL, err := url.Parse(v.AuthorizationEndpoint)
-	if err == nil {
-		endpoints.Authorization = linkURL
+	candidates := []struct {
+		rel string
+		val string
+		dst **url.URL
+	}{
+		{"authorization_endpoint", v.AuthorizationEndpoint, &endpoints.Authorization},
+		{"token_endpoint", v.TokenEndpoint, &endpoints.Token},
 	}
 
-	linkURL, err = url.Parse(v.TokenEndpoint)
-	if err == nil {
-		endpoints.Token = linkURL
+	for _, candidate := range candidates {
+		if candidate.val == "" {
+			report.reject(candidate.rel, "", SourceMetadata, "missing from metadata document")
+			continue
+		}
+
+		linkURL, err := metadataURL.Parse(candidate.val)
+		if err != nil {
+			report.reject(candidate.rel, candidate.val, SourceMetadata, "not a valid URL")
+			continue
+		}
+
+		*candidate.dst = linkURL
+		report.accept(candidate.rel, candidate.val, SourceMetadata, "read from metadata document")
+	}
+
+	optional := []struct {
+		val string
+		dst **url.URL
+	}{
+		{v.RevocationEndpoint, &endpoints.Revocation},
+		{v.IntrospectionEndpoint, &endpoints.Introspection},
+		{v.UserinfoEndpoint, &endpoints.Userinfo},
+	}
+
+	for _, candidate := range optional {
+		if candidate.val == "" {
+			continue
+		}
+
+		if linkURL, err := metadataURL.Parse(candidate.val); err == nil {
+			*candidate.dst = linkURL
+		}
 	}
 
 	return endpoints, nil
 }
 
-func (c *Config) findDirectly(url *url.URL, links []linkheader.Link) (endpoints Endpoints, err error) {
-	for _, link := range links {
-		if link.Rel == "authorization_endpoint" && endpoints.Authorization == nil {
-			linkURL, err := url.Parse(link.URL)
-			if err != nil {
-				return endpoints, err
-			}
-			endpoints.Authorization = linkURL
+// validIssuer returns true if issuer is a URL without a query or fragment that
+// is a prefix of metadataURL, as required by
+// https://indieauth.spec.indieweb.org/#indieauth-server-metadata. Otherwise the
+// document could claim to be for any issuer.
+func validIssuer(metadataURL *url.URL, issuer string) bool {
+	u, err := url.Parse(issuer)
+	if err != nil || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+
+	return isPrefixURL(issuer, metadataURL)
+}
+
+// isPrefixURL returns true if u is prefix, or is below it.
+func isPrefixURL(prefix string, u *url.URL) bool {
+	if u == nil {
+		return false
+	}
+
+	if u.Path == "" {
+		withSlash := *u
+		withSlash.Path = "/"
+		u = &withSlash
+	}
+
+	return strings.HasPrefix(u.String(), prefix)
+}
+
+func (c *Config) findDirectly(base *url.URL, links []linkheader.Link, source func(int) string, report *DiscoveryReport) (endpoints Endpoints, err error) {
+	for i, link := range links {
+		var dst **url.URL
+		switch link.Rel {
+		case "authorization_endpoint":
+			dst = &endpoints.Authorization
+		case "token_endpoint":
+			dst = &endpoints.Token
+		default:
+			continue
 		}
 
-		if link.Rel == "token_endpoint" && endpoints.Token == nil {
-			linkURL, err := url.Parse(link.URL)
-			if err != nil {
-				return endpoints, err
-			}
-			endpoints.Token = linkURL
+		if *dst != nil {
+			report.reject(link.Rel, link.URL, source(i), "an earlier "+link.Rel+" link is used")
+			continue
 		}
 
-		if endpoints.Authorization != nil && endpoints.Token != nil {
-			return endpoints, nil
+		linkURL, err := base.Parse(link.URL)
+		if err != nil {
+			report.reject(link.Rel, link.URL, source(i), "not a valid URL")
+			return endpoints, err
 		}
+
+		*dst = linkURL
+		report.accept(link.Rel, link.URL, source(i), "first "+link.Rel+" link")
+	}
+
+	return endpoints, nil
+}
+
+// findOthers sets the endpoints that are not used for authorization. As these
+// are not required, any that cannot be parsed are ignored.
+func findOthers(base *url.URL, links linkheader.Links, endpoints *Endpoints) {
+	others := []struct {
+		rel string
+		dst **url.URL
+	}{
+		{"micropub", &endpoints.Micropub},
+		{"microsub", &endpoints.Microsub},
+		{"webmention", &endpoints.Webmention},
+		{"media-endpoint", &endpoints.Media},
 	}
 
-	return endpoints, err
+	for _, other := range others {
+		found := links.FilterByRel(other.rel)
+		if len(found) == 0 {
+			continue
+		}
+
+		if linkURL, err := base.Parse(found[0].URL); err == nil {
+			*other.dst = linkURL
+		}
+	}
 }
@@ -1,9 +1,12 @@
 package indieauth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"hawx.me/code/assert"
@@ -29,6 +32,17 @@ func testMetadataEndpoint(body string) *httptest.Server {
 	}))
 }
 
+// testIssuerMetadataEndpoint serves body with "https://auth.example.com"
+// replaced by the URL of the server, so that the issuer it defines is valid.
+func testIssuerMetadataEndpoint(body string) *httptest.Server {
+	var s *httptest.Server
+	s = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, strings.ReplaceAll(body, "https://auth.example.com", s.URL))
+	}))
+	return s
+}
+
 func TestFindEndpoints(t *testing.T) {
 	homepage := testEndpointServer(`
 <html>
@@ -288,3 +302,177 @@ func TestFindEndpointsViaMetadataPreferLink(t *testing.T) {
 		assert.Equal(t, "http://example.com/what", endpoints.Token.String())
 	}
 }
+
+func TestFindEndpointsViaMetadataFull(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testIssuerMetadataEndpoint(`
+{
+	"issuer": "https://auth.example.com/",
+	"authorization_endpoint": "https://auth.example.com/auth",
+	"token_endpoint": "https://auth.example.com/token",
+	"introspection_endpoint": "https://auth.example.com/introspect",
+	"revocation_endpoint": "https://auth.example.com/revoke",
+	"userinfo_endpoint": "https://auth.example.com/userinfo",
+	"scopes_supported": ["profile", "email", "create"],
+	"response_types_supported": ["code"],
+	"grant_types_supported": ["authorization_code", "refresh_token"],
+	"code_challenge_methods_supported": ["S256"],
+	"authorization_response_iss_parameter_supported": true
+}
+`)
+	defer metadata.Close()
+
+	homepage := testEndpointServer("", http.Header{
+		"Link": {
+			`<` + metadata.URL + `>; rel="indieauth-metadata"`,
+		},
+	})
+	defer homepage.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+	assert(err).Must.Nil()
+	assert(endpoints.Metadata).Must.NotNil()
+
+	assert(endpoints.Authorization.String()).Equal(metadata.URL + "/auth")
+	assert(endpoints.Token.String()).Equal(metadata.URL + "/token")
+
+	m := endpoints.Metadata
+	assert(m.Issuer).Equal(metadata.URL + "/")
+	assert(m.IntrospectionEndpoint).Equal(metadata.URL + "/introspect")
+	assert(m.RevocationEndpoint).Equal(metadata.URL + "/revoke")
+	assert(m.UserinfoEndpoint).Equal(metadata.URL + "/userinfo")
+	assert(m.ResponseTypesSupported).Equal([]string{"code"})
+	assert(m.GrantTypesSupported).Equal([]string{"authorization_code", "refresh_token"})
+	assert(m.AuthorizationResponseIssParameterSupported).True()
+	assert(m.SupportsScope("create")).True()
+	assert(m.SupportsScope("delete")).False()
+	assert(m.SupportsCodeChallengeMethod("S256")).True()
+}
+
+func TestFindEndpointsViaMetadataWithInvalidIssuer(t *testing.T) {
+	testCases := map[string]string{
+		"other host":     "https://trusted.example.com/",
+		"not a prefix":   "/other/",
+		"with query":     "/?a=b",
+		"with fragment":  "/#a",
+		"not a full URL": "trusted",
+	}
+
+	for name, issuer := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			var metadata *httptest.Server
+			metadata = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				iss := issuer
+				if strings.HasPrefix(iss, "/") {
+					iss = metadata.URL + iss
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				fmt.Fprintf(w, `{"issuer": "%s", "authorization_endpoint": "/auth", "token_endpoint": "/token"}`, iss)
+			}))
+			defer metadata.Close()
+
+			homepage := testEndpointServer("", http.Header{
+				"Link": {`<` + metadata.URL + `/metadata>; rel="indieauth-metadata"`},
+			})
+			defer homepage.Close()
+
+			endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+			assert(err).Must.Nil()
+			assert(endpoints.Metadata).Must.NotNil()
+			assert(endpoints.Issuer()).Equal("")
+			assert(endpoints.Token.String()).Equal(metadata.URL + "/token")
+		})
+	}
+}
+
+func TestFindEndpointsDirectlyHasNoMetadata(t *testing.T) {
+	homepage := testEndpointServer(`<link rel="authorization_endpoint" href="http://example.com/hey" />`, nil)
+	defer homepage.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+
+	if assert.Nil(t, err) {
+		assert.Nil(t, endpoints.Metadata)
+	}
+}
+
+func TestEndpointsVerifyIssuer(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	withMetadata := Endpoints{Metadata: &Metadata{Issuer: "https://auth.example.com/"}}
+	assert(withMetadata.VerifyIssuer("https://auth.example.com/")).Nil()
+	assert(withMetadata.VerifyIssuer("")).Equal(ErrIssuerMissing)
+	assert(withMetadata.VerifyIssuer("https://evil.example.com/")).Equal(ErrIssuerMismatch)
+
+	withoutMetadata := Endpoints{}
+	assert(withoutMetadata.VerifyIssuer("")).Nil()
+}
+
+func TestFindEndpointsContextCancelled(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-ctx.Done()
+	}))
+	defer homepage.Close()
+
+	_, err := (&Config{}).FindEndpointsContext(ctx, homepage.URL)
+	assert(errors.Is(err, context.Canceled)).True()
+}
+
+func TestFindEndpointsOthers(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`
+<html>
+<head>
+<link rel="authorization_endpoint" href="http://example.com/auth" />
+<link rel="micropub" href="/micropub" />
+<link rel="microsub" href="https://aperture.example.com/microsub/1" />
+<link rel="webmention" href="http://example.com/webmention-html" />
+<link rel="media-endpoint" href="/media" />
+</head>
+</html>
+`, http.Header{
+		"Link": {
+			`<http://example.com/webmention>; rel="webmention"`,
+		},
+	})
+	defer homepage.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+	assert(err).Must.Nil()
+
+	assert(endpoints.Micropub.String()).Equal(homepage.URL + "/micropub")
+	assert(endpoints.Microsub.String()).Equal("https://aperture.example.com/microsub/1")
+	assert(endpoints.Webmention.String()).Equal("http://example.com/webmention")
+	assert(endpoints.Media.String()).Equal(homepage.URL + "/media")
+}
+
+func TestFindEndpointsOthersViaMetadata(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testMetadataEndpoint(`{"authorization_endpoint": "http://example.com/auth"}`)
+	defer metadata.Close()
+
+	homepage := testEndpointServer(`
+<link rel="indieauth-metadata" href="`+metadata.URL+`" />
+<link rel="micropub" href="/micropub" />
+`, nil)
+	defer homepage.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+	assert(err).Must.Nil()
+
+	assert(endpoints.Micropub.String()).Equal(homepage.URL + "/micropub")
+	assert(endpoints.Microsub).Nil()
+	assert(endpoints.Webmention).Nil()
+	assert(endpoints.Media).Nil()
+}
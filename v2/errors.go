@@ -22,6 +22,20 @@ func (e clientError) Error() string {
 		return "me returned with non-matching authorization endpoint"
 	case ErrAuthorizationEndpointMissing:
 		return "no authorization endpoint found"
+	case ErrIssuerMissing:
+		return "authorization response is missing iss"
+	case ErrIssuerMismatch:
+		return "authorization response iss does not match discovered issuer"
+	case ErrTokenEndpointMissing:
+		return "no token endpoint found"
+	case ErrUserinfoEndpointMissing:
+		return "no userinfo endpoint found"
+	case ErrFlowExpired:
+		return "no sign-in in progress"
+	case ErrStateMismatch:
+		return "unexpected state"
+	case ErrCodeMissing:
+		return "authorization response is missing code"
 	default:
 		panic("missing error definition")
 	}
@@ -35,4 +49,81 @@ const (
 	// ErrAuthorizationEndpointMissing means an authorization endpoint could not
 	// be found for the entered 'me'.
 	ErrAuthorizationEndpointMissing
+
+	// ErrIssuerMissing means the authorization response did not include an
+	// "iss" parameter, but the server metadata defined an issuer.
+	ErrIssuerMissing
+
+	// ErrIssuerMismatch means the "iss" parameter of the authorization response
+	// did not match the issuer discovered for the entered 'me'.
+	ErrIssuerMismatch
+
+	// ErrTokenEndpointMissing means a token endpoint is required for the
+	// request, but was not found for the user.
+	ErrTokenEndpointMissing
+
+	// ErrUserinfoEndpointMissing means the metadata document for the user did
+	// not define a userinfo endpoint.
+	ErrUserinfoEndpointMissing
+
+	// ErrFlowExpired means a sign-in was not in progress for the session, so
+	// the session may have expired, or the user may have followed an old link.
+	ErrFlowExpired
+
+	// ErrStateMismatch means the "state" parameter of the authorization
+	// response did not match the value stored in the session.
+	ErrStateMismatch
+
+	// ErrCodeMissing means the authorization response did not include a "code"
+	// parameter.
+	ErrCodeMissing
+)
+
+// ErrorCode is an OAuth 2.0 error code. It can be used with errors.Is to check
+// the code of an *OAuthError.
+type ErrorCode string
+
+func (e ErrorCode) Error() string {
+	return string(e)
+}
+
+// Error codes defined by RFC 6749 and RFC 6750.
+const (
+	ErrInvalidRequest          ErrorCode = "invalid_request"
+	ErrInvalidClient           ErrorCode = "invalid_client"
+	ErrInvalidGrant            ErrorCode = "invalid_grant"
+	ErrUnauthorizedClient      ErrorCode = "unauthorized_client"
+	ErrUnsupportedGrantType    ErrorCode = "unsupported_grant_type"
+	ErrUnsupportedResponseType ErrorCode = "unsupported_response_type"
+	ErrInvalidScope            ErrorCode = "invalid_scope"
+	ErrAccessDenied            ErrorCode = "access_denied"
+	ErrServerError             ErrorCode = "server_error"
+	ErrTemporarilyUnavailable  ErrorCode = "temporarily_unavailable"
+	ErrInvalidToken            ErrorCode = "invalid_token"
+	ErrInsufficientScope       ErrorCode = "insufficient_scope"
 )
+
+// OAuthError is an error response from the token or authorization endpoint,
+// as defined by https://tools.ietf.org/html/rfc6749#section-5.2.
+type OAuthError struct {
+	Code        ErrorCode
+	Description string
+	URI         string
+
+	// StatusCode and Body are from the response, for debugging.
+	StatusCode int
+	Body       []byte
+}
+
+func (e *OAuthError) Error() string {
+	if e.Description != "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Description)
+	}
+
+	return string(e.Code)
+}
+
+// Unwrap returns the Code, so that errors.Is(err, ErrInvalidGrant) can be used.
+func (e *OAuthError) Unwrap() error {
+	return e.Code
+}
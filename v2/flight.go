@@ -0,0 +1,83 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// flightGroup collapses concurrent discovery of the same profile URL into a
+// single call, the result of which is shared by every caller.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+
+	// waiting, if set, is called when a caller starts waiting on the flight for
+	// key. It is only used by tests.
+	waiting func(key string)
+}
+
+type flight struct {
+	done      chan struct{}
+	endpoints Endpoints
+	err       error
+
+	// abandoned is true if the context of the caller running the flight ended
+	// before it finished.
+	abandoned bool
+}
+
+// do calls fn for key, unless a call for key is already in progress in which
+// case it waits for that call to finish and returns its result. If the call
+// being waited on is abandoned because the context of its caller ended, but ctx
+// has not, then fn is tried again.
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (Endpoints, error)) (Endpoints, error) {
+	for {
+		g.mu.Lock()
+		if g.calls == nil {
+			g.calls = map[string]*flight{}
+		}
+
+		if f, ok := g.calls[key]; ok {
+			g.mu.Unlock()
+
+			if g.waiting != nil {
+				g.waiting(key)
+			}
+
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return Endpoints{}, ctx.Err()
+			}
+
+			if f.abandoned && ctx.Err() == nil {
+				continue
+			}
+
+			return f.endpoints, f.err
+		}
+
+		f := &flight{done: make(chan struct{})}
+		g.calls[key] = f
+		g.mu.Unlock()
+
+		g.run(ctx, key, f, fn)
+		return f.endpoints, f.err
+	}
+}
+
+func (g *flightGroup) run(ctx context.Context, key string, f *flight, fn func() (Endpoints, error)) {
+	defer func() {
+		f.abandoned = ctx.Err() != nil
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(f.done)
+	}()
+
+	// Set an error in case fn panics, so waiters do not see a nil error.
+	f.err = errors.New("discovery did not complete")
+	f.endpoints, f.err = fn()
+}
@@ -0,0 +1,154 @@
+package indieauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+// countWaiters sets a hook on g that sends to the returned channel each time a
+// caller starts waiting on a flight.
+func countWaiters(g *flightGroup) <-chan string {
+	waiting := make(chan string, 100)
+	g.waiting = func(key string) {
+		waiting <- key
+	}
+
+	return waiting
+}
+
+// waitForWaiters blocks until n callers are waiting on the flight for key.
+func waitForWaiters(t *testing.T, waiting <-chan string, key string, n int) {
+	timeout := time.After(5 * time.Second)
+
+	for n > 0 {
+		select {
+		case k := <-waiting:
+			if k == key {
+				n--
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %d waiters", n)
+		}
+	}
+}
+
+func TestFindEndpointsConcurrentDiscoveryIsShared(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	const callers = 10
+
+	var homepageRequests, metadataRequests int32
+	release := make(chan struct{})
+
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&metadataRequests, 1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"authorization_endpoint": "http://example.com/auth"}`)
+	}))
+	defer metadata.Close()
+
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&homepageRequests, 1)
+		<-release
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="indieauth-metadata" href="%s" />`, metadata.URL)
+	}))
+	defer homepage.Close()
+
+	config := &Config{}
+	waiting := countWaiters(&config.discoveries)
+
+	var wg sync.WaitGroup
+	results := make([]Endpoints, callers)
+	errs := make([]error, callers)
+
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], errs[i] = config.FindEndpoints(homepage.URL)
+		}(i)
+	}
+
+	waitForWaiters(t, waiting, homepage.URL+"/", callers-1)
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < callers; i++ {
+		assert(errs[i]).Nil()
+		assert(results[i].Authorization.String()).Equal("http://example.com/auth")
+	}
+
+	assert(atomic.LoadInt32(&homepageRequests)).Equal(int32(1))
+	assert(atomic.LoadInt32(&metadataRequests)).Equal(int32(1))
+}
+
+func TestFindEndpointsSequentialDiscoveryIsNotShared(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var requests int32
+
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<link rel="authorization_endpoint" href="http://example.com/auth" />`)
+	}))
+	defer homepage.Close()
+
+	config := &Config{}
+
+	for i := 0; i < 2; i++ {
+		_, err := config.FindEndpoints(homepage.URL)
+		assert(err).Nil()
+	}
+
+	assert(atomic.LoadInt32(&requests)).Equal(int32(2))
+}
+
+func TestFlightGroupRetriesWhenAbandoned(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var g flightGroup
+	var calls int32
+	waiting := countWaiters(&g)
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	started := make(chan struct{})
+
+	leaderDone := make(chan error)
+	go func() {
+		_, err := g.do(leaderCtx, "key", func() (Endpoints, error) {
+			atomic.AddInt32(&calls, 1)
+			close(started)
+			<-leaderCtx.Done()
+			return Endpoints{}, leaderCtx.Err()
+		})
+		leaderDone <- err
+	}()
+
+	<-started
+
+	waiterDone := make(chan error)
+	go func() {
+		_, err := g.do(context.Background(), "key", func() (Endpoints, error) {
+			atomic.AddInt32(&calls, 1)
+			return Endpoints{Me: "https://example.com/"}, nil
+		})
+		waiterDone <- err
+	}()
+
+	waitForWaiters(t, waiting, "key", 1)
+	cancelLeader()
+
+	assert(<-leaderDone).Equal(context.Canceled)
+	assert(<-waiterDone).Nil()
+	assert(atomic.LoadInt32(&calls)).Equal(int32(2))
+}
@@ -0,0 +1,127 @@
+package indieauth
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DisallowedAddressError is returned by a client created with
+// NewGuardedClient when a request would connect to a disallowed address.
+type DisallowedAddressError struct {
+	Address string
+}
+
+func (e *DisallowedAddressError) Error() string {
+	return fmt.Sprintf("connecting to %s is not allowed", e.Address)
+}
+
+var disallowedNetworks = mustParseCIDRs(
+	"0.0.0.0/8",      // "this" network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // carrier-grade NAT
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // IETF protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4/IPv6 translation
+	"fc00::/7",       // unique local
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+
+	return networks
+}
+
+func isDisallowedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	for _, network := range disallowedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewGuardedClient returns an *http.Client that will refuse to connect to
+// loopback, link-local, private, multicast and other non-public addresses. The
+// check is made after DNS resolution, for every connection, so it also applies
+// to redirects. Any hosts given in allowedHosts, either as "host" or
+// "host:port", can be connected to regardless of their address.
+//
+// The client does not use a proxy, as that would bypass the check.
+func NewGuardedClient(allowedHosts ...string) *http.Client {
+	return &http.Client{
+		Transport: newGuardedTransport(allowedHosts),
+	}
+}
+
+func newGuardedTransport(allowedHosts []string) *http.Transport {
+	allowed := map[string]bool{}
+	for _, host := range allowedHosts {
+		allowed[strings.ToLower(host)] = true
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	guardedDialer := &net.Dialer{
+		Timeout:   dialer.Timeout,
+		KeepAlive: dialer.KeepAlive,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || isDisallowedIP(ip) {
+				return &DisallowedAddressError{Address: address}
+			}
+
+			return nil
+		},
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if allowed[strings.ToLower(address)] || allowed[strings.ToLower(host)] {
+			return dialer.DialContext(ctx, network, address)
+		}
+
+		return guardedDialer.DialContext(ctx, network, address)
+	}
+
+	return transport
+}
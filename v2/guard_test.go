@@ -0,0 +1,94 @@
+package indieauth
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestIsDisallowedIP(t *testing.T) {
+	testCases := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"224.0.0.1":       true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"93.184.216.34":   false,
+		"2606:4700::1111": false,
+	}
+
+	for ip, expected := range testCases {
+		t.Run(ip, func(t *testing.T) {
+			assert.Equal(t, expected, isDisallowedIP(net.ParseIP(ip)))
+		})
+	}
+}
+
+func TestGuardedClient(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	_, err := NewGuardedClient().Get(ts.URL)
+
+	var addressErr *DisallowedAddressError
+	assert(errors.As(err, &addressErr)).True()
+}
+
+func TestGuardedClientAllowedHost(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	resp, err := NewGuardedClient("127.0.0.1").Get(ts.URL)
+	assert(err).Must.Nil()
+	resp.Body.Close()
+}
+
+func TestFindEndpointsGuardPrivateAddresses(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`<link rel="authorization_endpoint" href="http://example.com/auth" />`, nil)
+	defer homepage.Close()
+
+	_, err := (&Config{GuardPrivateAddresses: true}).FindEndpoints(homepage.URL)
+
+	var addressErr *DisallowedAddressError
+	assert(errors.As(err, &addressErr)).True()
+}
+
+func TestFindEndpointsGuardPrivateAddressesOnRedirect(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	internal := testEndpointServer(`<link rel="authorization_endpoint" href="http://example.com/auth" />`, nil)
+	defer internal.Close()
+
+	redirect := testRedirectServer(http.StatusFound, internal.URL)
+	defer redirect.Close()
+
+	redirectURL, _ := url.Parse(redirect.URL)
+	redirectURL.Host = "localhost:" + redirectURL.Port()
+
+	config := &Config{
+		GuardPrivateAddresses: true,
+		AllowedHosts:          []string{"localhost"},
+	}
+
+	_, err := config.FindEndpoints(redirectURL.String())
+
+	var addressErr *DisallowedAddressError
+	assert(errors.As(err, &addressErr)).True()
+}
@@ -1,53 +1,102 @@
 package indieauth
 
 import (
+	"io"
 	"strings"
 
 	"github.com/tomnomnom/linkheader"
 	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
-func findLinks(node *html.Node) linkheader.Links {
-	var links linkheader.Links
+// findLinks reads the <link> elements from the HTML document in r, appending
+// them to links. To avoid reading all of a large document, it stops at the end
+// of the <head> once links contains the rels needed for authorization.
+func findLinks(r io.Reader, links linkheader.Links) (linkheader.Links, error) {
+	z := html.NewTokenizer(r)
 
-	for _, link := range searchAll(node, isLink) {
-		for _, rel := range strings.Fields(getAttr(link, "rel")) {
-			links = append(links, linkheader.Link{
-				Rel: rel,
-				URL: getAttr(link, "href"),
-			})
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return links, err
+			}
+			return links, nil
+
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.Head && hasAuthorizationRels(links) {
+				return links, nil
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			tag := atom.Lookup(name)
+
+			if tag == atom.Link {
+				if hasAttr {
+					links = appendLink(z, links)
+				}
+				continue
+			}
+
+			if !isHeadElement(tag) && hasAuthorizationRels(links) {
+				return links, nil
+			}
 		}
 	}
-
-	return links
 }
 
-func searchAll(node *html.Node, pred func(*html.Node) bool) (results []*html.Node) {
-	if pred(node) {
-		results = append(results, node)
-		return
-	}
+func appendLink(z *html.Tokenizer, links linkheader.Links) linkheader.Links {
+	var rel, href string
+	var hasRel, hasHref bool
 
-	for child := node.FirstChild; child != nil; child = child.NextSibling {
-		result := searchAll(child, pred)
-		if len(result) > 0 {
-			results = append(results, result...)
+	for {
+		key, val, more := z.TagAttr()
+		switch string(key) {
+		case "rel":
+			if !hasRel {
+				rel, hasRel = string(val), true
+			}
+		case "href":
+			if !hasHref {
+				href, hasHref = string(val), true
+			}
 		}
+
+		if !more {
+			break
+		}
+	}
+
+	for _, r := range strings.Fields(rel) {
+		links = append(links, linkheader.Link{
+			Rel: r,
+			URL: href,
+		})
 	}
 
-	return
+	return links
 }
 
-func isLink(node *html.Node) bool {
-	return node.Type == html.ElementNode && node.Data == "link"
+// isHeadElement returns true for the elements that can appear before the
+// <body> of a document, any other element means the body has started.
+func isHeadElement(tag atom.Atom) bool {
+	switch tag {
+	case atom.Html, atom.Head, atom.Title, atom.Base, atom.Link, atom.Meta,
+		atom.Style, atom.Script, atom.Noscript, atom.Template:
+		return true
+	default:
+		return false
+	}
 }
 
-func getAttr(node *html.Node, attrName string) string {
-	for _, attr := range node.Attr {
-		if attr.Key == attrName {
-			return attr.Val
+func hasAuthorizationRels(links linkheader.Links) bool {
+	for _, link := range links {
+		if link.Rel == "indieauth-metadata" || link.Rel == "authorization_endpoint" {
+			return true
 		}
 	}
 
-	return ""
+	return false
 }
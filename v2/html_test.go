@@ -0,0 +1,164 @@
+package indieauth
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/tomnomnom/linkheader"
+	"golang.org/x/net/html"
+	"hawx.me/code/assert"
+)
+
+var errReadPastHead = errors.New("read past head")
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, errReadPastHead
+}
+
+func TestFindLinks(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	links, err := findLinks(strings.NewReader(`
+<!DOCTYPE html>
+<html>
+<head>
+<title>Me</title>
+<link rel="stylesheet" href="/style.css">
+<link rel="authorization_endpoint token_endpoint" href="/auth" />
+<link href="/micropub" rel="micropub">
+</head>
+<body></body>
+</html>
+`), nil)
+	assert(err).Must.Nil()
+
+	assert(links).Equal(linkheader.Links{
+		{Rel: "stylesheet", URL: "/style.css"},
+		{Rel: "authorization_endpoint", URL: "/auth"},
+		{Rel: "token_endpoint", URL: "/auth"},
+		{Rel: "micropub", URL: "/micropub"},
+	})
+}
+
+func TestFindLinksStopsAfterHead(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	r := io.MultiReader(strings.NewReader(`
+<html>
+<head>
+<link rel="authorization_endpoint" href="/auth" />
+</head>`), errReader{})
+
+	links, err := findLinks(r, nil)
+	assert(err).Nil()
+	assert(links).Len(1)
+}
+
+func TestFindLinksStopsAtBodyContent(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	r := io.MultiReader(strings.NewReader(`
+<link rel="authorization_endpoint" href="/auth" />
+<div>`), errReader{})
+
+	links, err := findLinks(r, nil)
+	assert(err).Nil()
+	assert(links).Len(1)
+}
+
+func TestFindLinksStopsWhenFoundInHeader(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	r := io.MultiReader(strings.NewReader(`<html><head></head>`), errReader{})
+
+	links, err := findLinks(r, linkheader.Links{
+		{Rel: "indieauth-metadata", URL: "/metadata"},
+	})
+	assert(err).Nil()
+	assert(links).Len(1)
+}
+
+func TestFindLinksContinuesIntoBody(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	links, err := findLinks(strings.NewReader(`
+<html>
+<head></head>
+<body>
+<p>Hello</p>
+<link rel="authorization_endpoint" href="/auth" />
+</body>
+</html>
+`), nil)
+	assert(err).Nil()
+	assert(links).Equal(linkheader.Links{
+		{Rel: "authorization_endpoint", URL: "/auth"},
+	})
+}
+
+func benchmarkDocument() string {
+	var entries strings.Builder
+	for i := 0; i < 2000; i++ {
+		entries.WriteString(`<article class="h-entry"><a class="u-url" href="/post">Post</a><div class="e-content"><p>Lorem ipsum dolor sit amet, consectetur adipiscing elit.</p></div></article>`)
+	}
+
+	return `<!DOCTYPE html>
+<html>
+<head>
+<title>Me</title>
+<link rel="authorization_endpoint" href="/auth" />
+<link rel="token_endpoint" href="/token" />
+</head>
+<body class="h-feed">` + entries.String() + `</body>
+</html>`
+}
+
+func BenchmarkFindLinks(b *testing.B) {
+	doc := benchmarkDocument()
+	b.SetBytes(int64(len(doc)))
+
+	for i := 0; i < b.N; i++ {
+		findLinks(strings.NewReader(doc), nil)
+	}
+}
+
+// BenchmarkFindLinksParseTree measures the previous approach of parsing the
+// whole document into a tree, then searching it for <link> elements.
+func BenchmarkFindLinksParseTree(b *testing.B) {
+	doc := benchmarkDocument()
+	b.SetBytes(int64(len(doc)))
+
+	for i := 0; i < b.N; i++ {
+		root, _ := html.Parse(strings.NewReader(doc))
+		findLinksInTree(root)
+	}
+}
+
+func findLinksInTree(node *html.Node) (links linkheader.Links) {
+	if node.Type == html.ElementNode && node.Data == "link" {
+		var rel, href string
+		for _, attr := range node.Attr {
+			switch attr.Key {
+			case "rel":
+				rel = attr.Val
+			case "href":
+				href = attr.Val
+			}
+		}
+
+		for _, r := range strings.Fields(rel) {
+			links = append(links, linkheader.Link{Rel: r, URL: href})
+		}
+		return
+	}
+
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		links = append(links, findLinksInTree(child)...)
+	}
+
+	return
+}
@@ -4,12 +4,16 @@
 package indieauth
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"mime"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Config defines a client for authorizing users to perform a set of defined
@@ -19,10 +23,65 @@ type Config struct {
 	RedirectURL string
 	Scopes      []string
 	Client      *http.Client
+
+	// Cache, if set, stores the responses fetched during endpoint discovery
+	// according to their caching headers.
+	Cache DiscoveryCache
+
+	// GuardPrivateAddresses, when true and Client is nil, makes requests using a
+	// client created by NewGuardedClient. This should be set when users can
+	// enter any URL, to prevent requests being made to internal services.
+	GuardPrivateAddresses bool
+
+	// AllowedHosts are hosts that may be connected to when
+	// GuardPrivateAddresses is set, useful for development.
+	AllowedHosts []string
+
+	// MaxProfileSize, MaxMetadataSize and MaxResponseSize limit the number of
+	// bytes read from the profile page, metadata document and token or
+	// authorization endpoint responses respectively. If zero the corresponding
+	// default limit is used. Exceeding a limit results in a *BodyTooLargeError.
+	MaxProfileSize  int64
+	MaxMetadataSize int64
+	MaxResponseSize int64
+
+	// IntrospectionToken is used as a Bearer token to authorize requests made
+	// to an introspection endpoint by Introspect.
+	IntrospectionToken string
+
+	// ProfileTimeout, MetadataTimeout and ExchangeTimeout limit the time taken
+	// fetching the profile page, fetching the metadata document and making
+	// requests to the token or authorization endpoint respectively. If zero no
+	// timeout, other than that of the context or Client, is applied.
+	ProfileTimeout  time.Duration
+	MetadataTimeout time.Duration
+	ExchangeTimeout time.Duration
+
+	guardOnce   sync.Once
+	guardClient *http.Client
+	discoveries flightGroup
 }
 
-// AuthCodeURL returns a URL to the authorization provider.
-func (c *Config) AuthCodeURL(endpoints Endpoints, state, codeChallenge, me string) string {
+func (c *Config) client() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+
+	if c.GuardPrivateAddresses {
+		c.guardOnce.Do(func() {
+			c.guardClient = NewGuardedClient(c.AllowedHosts...)
+		})
+
+		return c.guardClient
+	}
+
+	return http.DefaultClient
+}
+
+// AuthCodeURL returns a URL to the authorization provider. Any opts are added
+// as extra parameters, except those that would overwrite a parameter set by
+// AuthCodeURL, which are ignored. Use CheckAuthCodeOptions to find such opts.
+func (c *Config) AuthCodeURL(endpoints Endpoints, state, codeChallenge, me string, opts ...AuthCodeOption) string {
 	form := url.Values{
 		"response_type":         {"code"},
 		"client_id":             {c.ClientID},
@@ -33,6 +92,9 @@ func (c *Config) AuthCodeURL(endpoints Endpoints, state, codeChallenge, me strin
 	}
 
 	if me != "" {
+		if meURL, err := ParseProfileURL(me); err == nil {
+			me = meURL.String()
+		}
 		form.Set("me", me)
 	}
 
@@ -40,6 +102,8 @@ func (c *Config) AuthCodeURL(endpoints Endpoints, state, codeChallenge, me strin
 		form.Set("scope", strings.Join(c.Scopes, " "))
 	}
 
+	applyOptions(form, opts)
+
 	queryURL := &url.URL{
 		RawQuery: form.Encode(),
 	}
@@ -50,15 +114,25 @@ func (c *Config) AuthCodeURL(endpoints Endpoints, state, codeChallenge, me strin
 // Exchange converts an authorization code into a token or profile
 // information. The code will be in the query string of the request sent to the
 // RedirectURL, before calling this method ensure you check the state parameter
-// matches the value used for AuthCodeURL.
+// matches the value used for AuthCodeURL, and that the iss parameter is valid
+// using endpoints.VerifyIssuer.
 //
 // If Scopes is empty, "profile", or "profile email", the response will not
 // contain an access token.
+//
+// If the endpoint returns an error response an *OAuthError is returned, which
+// can be checked with errors.Is(err, ErrInvalidGrant) and similar.
+//
+// The returned Me will be the canonical profile URL, after following any
+// permanent redirects.
 func (c *Config) Exchange(endpoints Endpoints, codeVerifier, code string) (*Response, error) {
-	client := http.DefaultClient
-	if c.Client != nil {
-		client = c.Client
-	}
+	return c.ExchangeContext(context.Background(), endpoints, codeVerifier, code)
+}
+
+// ExchangeContext is like Exchange but uses ctx for the requests made.
+func (c *Config) ExchangeContext(ctx context.Context, endpoints Endpoints, codeVerifier, code string) (*Response, error) {
+	exchangeCtx, cancel := withTimeout(ctx, c.ExchangeTimeout)
+	defer cancel()
 
 	form := url.Values{
 		"grant_type":    {"authorization_code"},
@@ -73,56 +147,156 @@ func (c *Config) Exchange(endpoints Endpoints, codeVerifier, code string) (*Resp
 		endpoint = endpoints.Authorization
 	}
 
-	req, err := http.NewRequest("POST", endpoint.String(), strings.NewReader(form.Encode()))
+	data, err := c.postForm(exchangeCtx, endpoint, form)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	req.Header.Set("Accept", "application/json")
 
-	resp, err := client.Do(req)
+	newEndpoints, err := c.FindEndpointsContext(ctx, data.Me)
+	if err != nil {
+		return nil, err
+	}
+
+	if newEndpoints.Authorization.String() != endpoints.Authorization.String() {
+		return nil, ErrCannotClaim
+	}
+
+	response := data.response(time.Now())
+	response.Me = newEndpoints.Me
+
+	return response, nil
+}
+
+type tokenResponse struct {
+	AccessToken  string   `json:"access_token"`
+	TokenType    string   `json:"token_type"`
+	Scope        string   `json:"scope"`
+	Me           string   `json:"me"`
+	Profile      *Profile `json:"profile"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int64    `json:"expires_in"`
+}
+
+func (data tokenResponse) response(now time.Time) *Response {
+	response := &Response{
+		AccessToken:  data.AccessToken,
+		TokenType:    data.TokenType,
+		Scopes:       strings.Fields(data.Scope),
+		Me:           data.Me,
+		Profile:      data.Profile,
+		RefreshToken: data.RefreshToken,
+		ExpiresIn:    data.ExpiresIn,
+	}
+
+	if data.ExpiresIn > 0 {
+		response.Expiry = now.Add(time.Duration(data.ExpiresIn) * time.Second)
+	}
+
+	return response
+}
+
+// postForm makes a POST request to endpoint, as is done to exchange an
+// authorization code, and decodes the JSON response.
+func (c *Config) postForm(ctx context.Context, endpoint *url.URL, form url.Values) (*tokenResponse, error) {
+	resp, err := c.post(ctx, endpoint, form)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
-	if resp.StatusCode != http.StatusOK || mediatype != "application/json" {
-		data, _ := ioutil.ReadAll(resp.Body)
-		return nil, &RequestError{
-			StatusCode: resp.StatusCode,
-			MediaType:  mediatype,
-			Body:       data,
-		}
+	if mediatype != "application/json" {
+		return nil, requestError(resp)
 	}
 
-	var data struct {
-		AccessToken string                 `json:"access_token"`
-		TokenType   string                 `json:"token_type"`
-		Scope       string                 `json:"scope"`
-		Me          string                 `json:"me"`
-		Profile     map[string]interface{} `json:"profile"`
-	}
+	var data tokenResponse
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 		return nil, err
 	}
 
-	newEndpoints, err := c.FindEndpoints(data.Me)
+	return &data, nil
+}
+
+// post makes a form encoded POST request to endpoint. If the response does not
+// have a 200 status a *RequestError is returned, otherwise the caller must
+// close the response body.
+func (c *Config) post(ctx context.Context, endpoint *url.URL, form url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
 
-	if newEndpoints.Authorization.String() != endpoints.Authorization.String() {
-		return nil, ErrCannotClaim
+	return c.do(req)
+}
+
+// getWithToken makes a GET request to endpoint, with token as a Bearer token.
+// If the response does not have a 200 status a *RequestError is returned,
+// otherwise the caller must close the response body.
+func (c *Config) getWithToken(ctx context.Context, endpoint *url.URL, token string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+
+	return c.do(req)
+}
+
+// do sends req, limiting the size of the response body. If the response does
+// not have a 200 status a *RequestError is returned, otherwise the caller must
+// close the response body.
+func (c *Config) do(req *http.Request) (*http.Response, error) {
+	resp, err := c.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = limitBody(resp.Body, c.maxResponseSize(), req.URL.String())
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, requestError(resp)
 	}
 
-	return &Response{
-		AccessToken: data.AccessToken,
-		TokenType:   data.TokenType,
-		Scopes:      strings.Fields(data.Scope),
-		Me:          data.Me,
-		Profile:     data.Profile,
-	}, nil
+	return resp, nil
+}
+
+// requestError reads the body of resp into a *RequestError. If the body is a
+// JSON error response an *OAuthError is returned, or if the body is too large
+// then a *BodyTooLargeError is returned instead.
+func requestError(resp *http.Response) error {
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	data, err := ioutil.ReadAll(resp.Body)
+	var tooLarge *BodyTooLargeError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+
+	if mediatype == "application/json" {
+		var v struct {
+			Error            string `json:"error"`
+			ErrorDescription string `json:"error_description"`
+			ErrorURI         string `json:"error_uri"`
+		}
+		if json.Unmarshal(data, &v) == nil && v.Error != "" {
+			return &OAuthError{
+				Code:        ErrorCode(v.Error),
+				Description: v.ErrorDescription,
+				URI:         v.ErrorURI,
+				StatusCode:  resp.StatusCode,
+				Body:        data,
+			}
+		}
+	}
+
+	return &RequestError{
+		StatusCode: resp.StatusCode,
+		MediaType:  mediatype,
+		Body:       data,
+	}
 }
 
 func (c *Config) isProfile() bool {
@@ -1,11 +1,14 @@
 package indieauth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"hawx.me/code/assert"
 )
@@ -95,7 +98,7 @@ func TestAuthCodeURL(t *testing.T) {
 		"?client_id=https%3A%2F%2Fwebapp.example.com%2F" +
 		"&code_challenge=challenge" +
 		"&code_challenge_method=S256" +
-		"&me=https%3A%2F%2Fme.example.com" +
+		"&me=https%3A%2F%2Fme.example.com%2F" +
 		"&redirect_uri=https%3A%2F%2Fwebapp.example.com%2Fcallback" +
 		"&response_type=code" +
 		"&scope=create+update+delete" +
@@ -104,6 +107,45 @@ func TestAuthCodeURL(t *testing.T) {
 	assert(redirectURL).Equal(expectedRedirect)
 }
 
+func TestAuthCodeURLWithOptions(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	session := &Config{
+		ClientID:    "https://webapp.example.com/",
+		RedirectURL: "https://webapp.example.com/callback",
+		Scopes:      []string{"create"},
+	}
+
+	endpoints := Endpoints{
+		Authorization: urlParse("https://auth.example.com/"),
+	}
+
+	redirectURL := session.AuthCodeURL(endpoints, "1234", "challenge", "",
+		Prompt("consent"),
+		LoginHint("john"),
+		SetAuthURLParam("scope", "create update"),
+		SetAuthURLParam("ext", "value"),
+		SetAuthURLParam("state", "evil"),
+		SetAuthURLParam("code_challenge", "evil"))
+
+	query := urlParse(redirectURL).Query()
+	assert(query.Get("prompt")).Equal("consent")
+	assert(query.Get("login_hint")).Equal("john")
+	assert(query.Get("ext")).Equal("value")
+	assert(query.Get("scope")).Equal("create")
+	assert(query.Get("state")).Equal("1234")
+	assert(query.Get("code_challenge")).Equal("challenge")
+}
+
+func TestCheckAuthCodeOptions(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	assert(CheckAuthCodeOptions()).Nil()
+	assert(CheckAuthCodeOptions(Prompt("login"), SetAuthURLParam("ext", "value"))).Nil()
+	assert(CheckAuthCodeOptions(Prompt("login"), SetAuthURLParam("scope", "create"))).
+		Equal(&ReservedParameterError{Key: "scope"})
+}
+
 func TestExchange(t *testing.T) {
 	assert := assert.Wrap(t)
 
@@ -137,7 +179,7 @@ func TestExchange(t *testing.T) {
 	assert(token.HasScope("create")).True()
 	assert(token.HasScope("update")).True()
 	assert(token.HasScope("delete")).True()
-	assert(token.Me).Equal(ms.URL)
+	assert(token.Me).Equal(ms.URL + "/")
 }
 
 func TestExchangeOnlyMe(t *testing.T) {
@@ -169,9 +211,9 @@ func TestExchangeOnlyMe(t *testing.T) {
 	assert(token.AccessToken).Equal("")
 	assert(token.TokenType).Equal("")
 	assert(token.Scopes).Len(0)
-	assert(token.Me).Equal(ms.URL)
-	assert(token.Profile).Equal(map[string]interface{}{
-		"name": "John Doe",
+	assert(token.Me).Equal(ms.URL + "/")
+	assert(token.Profile).Equal(&Profile{
+		Name: "John Doe",
 	})
 }
 
@@ -203,3 +245,113 @@ func TestExchangeCannotBeClaimed(t *testing.T) {
 	assert(err).Equal(ErrCannotClaim)
 	assert(token).Nil()
 }
+
+func TestExchangeContextCancelled(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-ctx.Done()
+	}))
+	defer ts.Close()
+
+	config := &Config{
+		ClientID:    "http://localhost",
+		RedirectURL: "http://localhost/callback",
+	}
+
+	_, err := config.ExchangeContext(ctx, Endpoints{Authorization: urlParse(ts.URL)}, "verifier", "abcde")
+	assert(errors.Is(err, context.Canceled)).True()
+}
+
+func TestExchangeWithRefreshToken(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var ms *httptest.Server
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "tokentoken", "token_type": "Bearer", "scope": "create", "me": "%s", "refresh_token": "refreshrefresh", "expires_in": 60}`, ms.URL)
+	}))
+	defer ts.Close()
+
+	ms = httptest.NewServer(&testMeEndpoint{auth: "http://example.com/auth"})
+	defer ms.Close()
+
+	config := &Config{
+		ClientID:    "http://localhost",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{"create"},
+	}
+
+	endpoints := Endpoints{
+		Authorization: urlParse("http://example.com/auth"),
+		Token:         urlParse(ts.URL),
+	}
+
+	token, err := config.Exchange(endpoints, "verifier", "abcde")
+	assert(err).Must.Nil()
+
+	assert(token.RefreshToken).Equal("refreshrefresh")
+	assert(token.ExpiresIn).Equal(int64(60))
+	assert(token.Expiry.IsZero()).False()
+	assert(time.Until(token.Expiry) <= time.Minute).True()
+}
+
+func TestExchangeOAuthError(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	body := `{"error": "invalid_grant", "error_description": "The code has expired", "error_uri": "https://example.com/errors"}`
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, body)
+	}))
+	defer ts.Close()
+
+	config := &Config{
+		ClientID:    "http://localhost",
+		RedirectURL: "http://localhost/callback",
+		Scopes:      []string{"create"},
+	}
+
+	_, err := config.Exchange(Endpoints{Token: urlParse(ts.URL)}, "verifier", "abcde")
+
+	assert(errors.Is(err, ErrInvalidGrant)).True()
+	assert(errors.Is(err, ErrInvalidClient)).False()
+
+	var oauthErr *OAuthError
+	assert(errors.As(err, &oauthErr)).Must.True()
+	assert(oauthErr.Code).Equal(ErrInvalidGrant)
+	assert(oauthErr.Description).Equal("The code has expired")
+	assert(oauthErr.URI).Equal("https://example.com/errors")
+	assert(oauthErr.StatusCode).Equal(http.StatusBadRequest)
+	assert(string(oauthErr.Body)).Equal(body)
+	assert(oauthErr.Error()).Equal("invalid_grant: The code has expired")
+}
+
+func TestExchangeNonOAuthError(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `{"message": "oops"}`)
+	}))
+	defer ts.Close()
+
+	config := &Config{
+		ClientID:    "http://localhost",
+		RedirectURL: "http://localhost/callback",
+	}
+
+	_, err := config.Exchange(Endpoints{Authorization: urlParse(ts.URL)}, "verifier", "abcde")
+
+	var requestErr *RequestError
+	assert(errors.As(err, &requestErr)).Must.True()
+	assert(requestErr.StatusCode).Equal(http.StatusInternalServerError)
+	assert(string(requestErr.Body)).Equal(`{"message": "oops"}`)
+}
@@ -0,0 +1,150 @@
+package indieauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Introspection is the result of checking a token.
+type Introspection struct {
+	// Active is true if the token is valid. If false the other fields will be
+	// empty.
+	Active bool
+
+	Me       string
+	ClientID string
+	Scopes   []string
+
+	// Expiry is the time the token expires, and IssuedAt the time it was
+	// issued. Either will be zero if the endpoint did not provide it.
+	Expiry   time.Time
+	IssuedAt time.Time
+}
+
+// HasScope returns true if the token was issued with the scope.
+func (i Introspection) HasScope(scope string) bool {
+	return contains(i.Scopes, scope)
+}
+
+// Introspect checks whether token is valid, and if so returns the information
+// associated with it. If the endpoints were discovered using a metadata
+// document that defines an introspection endpoint it is used, as in RFC 7662,
+// with IntrospectionToken authorizing the request. Otherwise the legacy method
+// of making a GET request to the token endpoint with the token is used.
+func (c *Config) Introspect(endpoints Endpoints, token string) (*Introspection, error) {
+	return c.IntrospectContext(context.Background(), endpoints, token)
+}
+
+// IntrospectContext is like Introspect but uses ctx for the requests made.
+func (c *Config) IntrospectContext(ctx context.Context, endpoints Endpoints, token string) (*Introspection, error) {
+	ctx, cancel := withTimeout(ctx, c.ExchangeTimeout)
+	defer cancel()
+
+	if endpoints.Introspection != nil {
+		return c.introspect(ctx, endpoints.Introspection, token)
+	}
+
+	if endpoints.Token == nil {
+		return nil, ErrTokenEndpointMissing
+	}
+
+	return c.introspectLegacy(ctx, endpoints.Token, token)
+}
+
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Me       string `json:"me"`
+	ClientID string `json:"client_id"`
+	Scope    string `json:"scope"`
+	Exp      int64  `json:"exp"`
+	Iat      int64  `json:"iat"`
+}
+
+func (data introspectionResponse) introspection() *Introspection {
+	if !data.Active {
+		return &Introspection{}
+	}
+
+	introspection := &Introspection{
+		Active:   true,
+		Me:       data.Me,
+		ClientID: data.ClientID,
+		Scopes:   strings.Fields(data.Scope),
+	}
+
+	if data.Exp > 0 {
+		introspection.Expiry = time.Unix(data.Exp, 0)
+	}
+	if data.Iat > 0 {
+		introspection.IssuedAt = time.Unix(data.Iat, 0)
+	}
+
+	return introspection
+}
+
+func (c *Config) introspect(ctx context.Context, endpoint *url.URL, token string) (*Introspection, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint.String(), strings.NewReader(url.Values{
+		"token": {token},
+	}.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.IntrospectionToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.IntrospectionToken)
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	return data.introspection(), nil
+}
+
+func (c *Config) introspectLegacy(ctx context.Context, endpoint *url.URL, token string) (*Introspection, error) {
+	resp, err := c.getWithToken(ctx, endpoint, token)
+	if err != nil {
+		var requestErr *RequestError
+		if errors.As(err, &requestErr) && isInvalidTokenStatus(requestErr.StatusCode) {
+			return &Introspection{}, nil
+		}
+
+		var oauthErr *OAuthError
+		if errors.As(err, &oauthErr) && isInvalidTokenStatus(oauthErr.StatusCode) {
+			return &Introspection{}, nil
+		}
+
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var data introspectionResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+
+	// The legacy response has no "active" property, instead a valid token is
+	// indicated by the response containing "me".
+	data.Active = data.Me != ""
+
+	return data.introspection(), nil
+}
+
+func isInvalidTokenStatus(statusCode int) bool {
+	return statusCode == http.StatusBadRequest ||
+		statusCode == http.StatusUnauthorized ||
+		statusCode == http.StatusForbidden
+}
@@ -0,0 +1,116 @@
+package indieauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestIntrospect(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Authorization") != "Bearer resourceserver" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.FormValue("token") != "tokentoken" {
+			fmt.Fprint(w, `{"active": false}`)
+			return
+		}
+
+		fmt.Fprint(w, `{
+  "active": true,
+  "me": "https://me.example.com/",
+  "client_id": "https://app.example.com/",
+  "scope": "create update",
+  "exp": 1632443647,
+  "iat": 1632443047
+}`)
+	}))
+	defer ts.Close()
+
+	config := &Config{IntrospectionToken: "resourceserver"}
+	endpoints := Endpoints{
+		Token:         urlParse("http://example.com/token"),
+		Introspection: urlParse(ts.URL),
+	}
+
+	introspection, err := config.Introspect(endpoints, "tokentoken")
+	assert(err).Must.Nil()
+
+	assert(introspection.Active).True()
+	assert(introspection.Me).Equal("https://me.example.com/")
+	assert(introspection.ClientID).Equal("https://app.example.com/")
+	assert(introspection.Scopes).Equal([]string{"create", "update"})
+	assert(introspection.HasScope("update")).True()
+	assert(introspection.Expiry.Equal(time.Unix(1632443647, 0))).True()
+	assert(introspection.IssuedAt.Equal(time.Unix(1632443047, 0))).True()
+
+	introspection, err = config.Introspect(endpoints, "badtoken")
+	assert(err).Must.Nil()
+	assert(introspection.Active).False()
+	assert(introspection.Me).Equal("")
+}
+
+func TestIntrospectLegacy(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.Header.Get("Authorization") != "Bearer tokentoken" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"me": "https://me.example.com/", "client_id": "https://app.example.com/", "scope": "create"}`)
+	}))
+	defer ts.Close()
+
+	endpoints := Endpoints{Token: urlParse(ts.URL)}
+
+	introspection, err := (&Config{}).Introspect(endpoints, "tokentoken")
+	assert(err).Must.Nil()
+
+	assert(introspection.Active).True()
+	assert(introspection.Me).Equal("https://me.example.com/")
+	assert(introspection.ClientID).Equal("https://app.example.com/")
+	assert(introspection.Scopes).Equal([]string{"create"})
+	assert(introspection.Expiry.IsZero()).True()
+
+	introspection, err = (&Config{}).Introspect(endpoints, "badtoken")
+	assert(err).Must.Nil()
+	assert(introspection.Active).False()
+}
+
+func TestIntrospectLegacyServerError(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	_, err := (&Config{}).Introspect(Endpoints{Token: urlParse(ts.URL)}, "tokentoken")
+	assert(err).NotNil()
+}
+
+func TestFindEndpointsIntrospectionViaMetadata(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testMetadataEndpoint(`{"authorization_endpoint": "/auth", "introspection_endpoint": "https://auth.example.com/introspect"}`)
+	defer metadata.Close()
+
+	homepage := testEndpointServer(`<link rel="indieauth-metadata" href="`+metadata.URL+`" />`, nil)
+	defer homepage.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+	assert(err).Must.Nil()
+	assert(endpoints.Introspection.String()).Equal("https://auth.example.com/introspect")
+}
@@ -0,0 +1,93 @@
+package indieauth
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Default limits for the size of response bodies read, used when the
+// corresponding field of Config is zero.
+const (
+	DefaultMaxProfileSize  = 5 << 20
+	DefaultMaxMetadataSize = 1 << 20
+	DefaultMaxResponseSize = 1 << 20
+)
+
+// BodyTooLargeError is returned when a response body is larger than the limit
+// configured for it.
+type BodyTooLargeError struct {
+	URL   string
+	Limit int64
+}
+
+func (e *BodyTooLargeError) Error() string {
+	return fmt.Sprintf("response from %s exceeded limit of %d bytes", e.URL, e.Limit)
+}
+
+func (c *Config) maxProfileSize() int64 {
+	return limitOrDefault(c.MaxProfileSize, DefaultMaxProfileSize)
+}
+
+func (c *Config) maxMetadataSize() int64 {
+	return limitOrDefault(c.MaxMetadataSize, DefaultMaxMetadataSize)
+}
+
+func (c *Config) maxResponseSize() int64 {
+	return limitOrDefault(c.MaxResponseSize, DefaultMaxResponseSize)
+}
+
+func limitOrDefault(limit, def int64) int64 {
+	if limit <= 0 {
+		return def
+	}
+
+	return limit
+}
+
+// withTimeout returns a context that is cancelled after timeout, if timeout is
+// greater than zero.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// limitBody wraps body so that reading more than limit bytes from it returns a
+// *BodyTooLargeError.
+func limitBody(body io.ReadCloser, limit int64, url string) io.ReadCloser {
+	return &limitedBody{
+		ReadCloser: body,
+		remaining:  limit,
+		err:        &BodyTooLargeError{URL: url, Limit: limit},
+	}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	err       error
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining < 0 {
+		return 0, b.err
+	}
+
+	// Read one byte more than remaining, so that a body of exactly the limit is
+	// not treated as too large.
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n + int(b.remaining), b.err
+	}
+
+	return n, err
+}
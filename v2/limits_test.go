@@ -0,0 +1,108 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestFindEndpointsProfileTooLarge(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`<html><head>`+strings.Repeat("<!-- padding -->", 100)+`
+<link rel="authorization_endpoint" href="http://example.com/auth" />`, nil)
+	defer homepage.Close()
+
+	_, err := (&Config{MaxProfileSize: 512}).FindEndpoints(homepage.URL)
+
+	var tooLarge *BodyTooLargeError
+	assert(errors.As(err, &tooLarge)).Must.True()
+	assert(tooLarge.Limit).Equal(int64(512))
+}
+
+func TestFindEndpointsProfileTooLargeCached(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(strings.Repeat("<!-- padding -->", 100), http.Header{
+		"Cache-Control": {"max-age=60"},
+	})
+	defer homepage.Close()
+
+	_, err := (&Config{MaxProfileSize: 512, Cache: NewMemoryCache()}).FindEndpoints(homepage.URL)
+
+	var tooLarge *BodyTooLargeError
+	assert(errors.As(err, &tooLarge)).True()
+}
+
+func TestFindEndpointsMetadataTooLarge(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testMetadataEndpoint(`{"authorization_endpoint": "http://example.com/auth", "scopes_supported": ["` +
+		strings.Repeat("a", 1024) + `"]}`)
+	defer metadata.Close()
+
+	homepage := testEndpointServer(`<link rel="indieauth-metadata" href="`+metadata.URL+`" />`, nil)
+	defer homepage.Close()
+
+	_, err := (&Config{MaxMetadataSize: 512}).FindEndpoints(homepage.URL)
+
+	var tooLarge *BodyTooLargeError
+	assert(errors.As(err, &tooLarge)).True()
+}
+
+func TestExchangeErrorResponseTooLarge(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, strings.Repeat("a", 1024))
+	}))
+	defer ts.Close()
+
+	config := &Config{
+		ClientID:        "http://localhost",
+		RedirectURL:     "http://localhost/callback",
+		MaxResponseSize: 512,
+	}
+
+	_, err := config.Exchange(Endpoints{Authorization: urlParse(ts.URL)}, "verifier", "abcde")
+
+	var tooLarge *BodyTooLargeError
+	assert(errors.As(err, &tooLarge)).True()
+}
+
+func TestFindEndpointsProfileTimeout(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	done := make(chan struct{})
+	homepage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer homepage.Close()
+	defer close(done)
+
+	_, err := (&Config{ProfileTimeout: 10 * time.Millisecond}).FindEndpoints(homepage.URL)
+	assert(errors.Is(err, context.DeadlineExceeded)).True()
+}
+
+func TestLimitBody(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	data, err := ioutil.ReadAll(limitBody(ioutil.NopCloser(strings.NewReader("hello")), 5, "http://example.com"))
+	assert(err).Nil()
+	assert(string(data)).Equal("hello")
+
+	data, err = ioutil.ReadAll(limitBody(ioutil.NopCloser(strings.NewReader("hello!")), 5, "http://example.com"))
+	assert(string(data)).Equal("hello")
+
+	var tooLarge *BodyTooLargeError
+	assert(errors.As(err, &tooLarge)).True()
+}
@@ -0,0 +1,82 @@
+package indieauth
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// reservedParameters are set by AuthCodeURL and so can't be set by an
+// AuthCodeOption.
+var reservedParameters = map[string]bool{
+	"response_type":         true,
+	"client_id":             true,
+	"redirect_uri":          true,
+	"state":                 true,
+	"code_challenge":        true,
+	"code_challenge_method": true,
+	"me":                    true,
+
+	// scope is set from Config.Scopes, which Exchange also uses to decide which
+	// endpoint to redeem the code at.
+	"scope": true,
+}
+
+// ReservedParameterError is returned when an AuthCodeOption would overwrite a
+// parameter that is set by AuthCodeURL.
+type ReservedParameterError struct {
+	Key string
+}
+
+func (e *ReservedParameterError) Error() string {
+	return fmt.Sprintf("authorization parameter %q is reserved", e.Key)
+}
+
+// AuthCodeOption adds a parameter to the URL built by AuthCodeURL.
+type AuthCodeOption struct {
+	key, value string
+}
+
+// SetAuthURLParam sets the parameter key to value, this can be used for any
+// extension parameters supported by the authorization endpoint. To request
+// different scopes use a Config with different Scopes.
+//
+// Parameters set by AuthCodeURL, including "scope", are reserved. An option for
+// a reserved parameter is ignored by AuthCodeURL and returns a
+// *ReservedParameterError from RedirectToSignIn; use CheckAuthCodeOptions to
+// find them before calling AuthCodeURL.
+func SetAuthURLParam(key, value string) AuthCodeOption {
+	return AuthCodeOption{key: key, value: value}
+}
+
+// Prompt sets the "prompt" parameter, for example "login" to ask the user to
+// authenticate again, or "consent" to ask them to approve the request again.
+func Prompt(prompt string) AuthCodeOption {
+	return SetAuthURLParam("prompt", prompt)
+}
+
+// LoginHint sets the "login_hint" parameter.
+func LoginHint(hint string) AuthCodeOption {
+	return SetAuthURLParam("login_hint", hint)
+}
+
+// CheckAuthCodeOptions returns a *ReservedParameterError for the first option
+// that would overwrite a reserved parameter, or nil if all opts can be used.
+func CheckAuthCodeOptions(opts ...AuthCodeOption) error {
+	for _, opt := range opts {
+		if reservedParameters[opt.key] {
+			return &ReservedParameterError{Key: opt.key}
+		}
+	}
+
+	return nil
+}
+
+func applyOptions(form url.Values, opts []AuthCodeOption) {
+	for _, opt := range opts {
+		if reservedParameters[opt.key] {
+			continue
+		}
+
+		form.Set(opt.key, opt.value)
+	}
+}
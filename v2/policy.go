@@ -0,0 +1,122 @@
+package indieauth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// IssuerPolicy restricts the servers that a TokenVerifier will accept tokens
+// from. A token is accepted if either the issuer of the user's endpoints is
+// listed in Issuers, or the host of the user's profile URL is listed in
+// Domains. An empty IssuerPolicy accepts no tokens.
+type IssuerPolicy struct {
+	// Issuers are the trusted issuer identifiers. For servers that do not
+	// publish a metadata document the URL of the token endpoint is used as the
+	// issuer.
+	Issuers []string
+
+	// Domains are the hosts of trusted profile URLs. A domain beginning with "."
+	// also trusts any subdomain, so ".example.com" trusts
+	// "https://john.example.com/".
+	Domains []string
+}
+
+// Allows returns true if the policy trusts tokens issued for endpoints. A trusted
+// issuer is only allowed when the token and introspection endpoints are below
+// it, so a metadata document can't pair a trusted issuer with its own servers.
+func (p IssuerPolicy) Allows(endpoints Endpoints) bool {
+	if issuer := issuerOf(endpoints); issuer != "" && contains(p.Issuers, issuer) &&
+		isPrefixURL(issuer, endpoints.Token) &&
+		(endpoints.Introspection == nil || isPrefixURL(issuer, endpoints.Introspection)) {
+		return true
+	}
+
+	me, err := ParseProfileURL(endpoints.Me)
+	if err != nil {
+		return false
+	}
+
+	host := me.Hostname()
+	for _, domain := range p.Domains {
+		if host == strings.TrimPrefix(domain, ".") ||
+			(strings.HasPrefix(domain, ".") && strings.HasSuffix(host, domain)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// issuerOf returns the issuer identifier for endpoints, or the URL of the token
+// endpoint if no metadata was discovered.
+func issuerOf(endpoints Endpoints) string {
+	if issuer := endpoints.Issuer(); issuer != "" {
+		return issuer
+	}
+
+	if endpoints.Token != nil {
+		return endpoints.Token.String()
+	}
+
+	return ""
+}
+
+// VerifyClaim checks token for a user that claims to be me. The endpoints for me
+// are discovered, and the token is only checked if they are allowed by the
+// Policy. The token is then rejected unless it was issued to me, so that a user
+// of a shared server can't claim to be another user of that server.
+//
+// As with Verify, an *OAuthError with the code ErrInvalidToken is returned if
+// the token is not accepted.
+func (v *TokenVerifier) VerifyClaim(ctx context.Context, token, me string) (*Response, error) {
+	invalid := func(description string) (*Response, error) {
+		return nil, &OAuthError{Code: ErrInvalidToken, Description: description}
+	}
+
+	if v.Policy == nil {
+		return invalid("no issuer policy is configured")
+	}
+
+	if _, err := ParseProfileURL(me); err != nil {
+		return invalid("the claimed me is not a valid profile url")
+	}
+
+	return v.cached(token, me, func() (*Response, error) {
+		endpoints, err := v.config.FindEndpointsContext(ctx, me)
+		if err != nil {
+			return nil, fmt.Errorf("could not find endpoints: %w", err)
+		}
+
+		if !v.Policy.Allows(endpoints) {
+			return invalid("the access token issuer is not trusted")
+		}
+
+		response, err := v.introspect(ctx, endpoints, token)
+		if err != nil {
+			return nil, err
+		}
+
+		if !sameProfile(response.Me, endpoints.Me) {
+			return invalid("the access token was not issued for me")
+		}
+
+		return response, nil
+	})
+}
+
+// sameProfile returns true if a and b are the same profile URL, once
+// canonicalized.
+func sameProfile(a, b string) bool {
+	aURL, err := ParseProfileURL(a)
+	if err != nil {
+		return false
+	}
+
+	bURL, err := ParseProfileURL(b)
+	if err != nil {
+		return false
+	}
+
+	return aURL.String() == bURL.String()
+}
@@ -0,0 +1,285 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func TestIssuerPolicyAllows(t *testing.T) {
+	withMetadata := Endpoints{
+		Me:       "https://john.example.com/",
+		Token:    urlParse("https://example.net/token"),
+		Metadata: &Metadata{Issuer: "https://example.net/"},
+	}
+	withOtherTokenEndpoint := Endpoints{
+		Me:       "https://john.example.com/",
+		Token:    urlParse("https://token.example.net/"),
+		Metadata: &Metadata{Issuer: "https://example.net/"},
+	}
+	withOtherIntrospectionEndpoint := Endpoints{
+		Me:            "https://john.example.com/",
+		Token:         urlParse("https://example.net/token"),
+		Introspection: urlParse("https://introspect.example.net/"),
+		Metadata:      &Metadata{Issuer: "https://example.net/"},
+	}
+	withoutMetadata := Endpoints{
+		Me:    "https://john.example.com/",
+		Token: urlParse("https://token.example.net/"),
+	}
+
+	testCases := map[string]struct {
+		policy    IssuerPolicy
+		endpoints Endpoints
+		allowed   bool
+	}{
+		"empty":                {IssuerPolicy{}, withMetadata, false},
+		"issuer":               {IssuerPolicy{Issuers: []string{"https://example.net/"}}, withMetadata, true},
+		"other issuer":         {IssuerPolicy{Issuers: []string{"https://example.org/"}}, withMetadata, false},
+		"token elsewhere":      {IssuerPolicy{Issuers: []string{"https://example.net/"}}, withOtherTokenEndpoint, false},
+		"introspect elsewhere": {IssuerPolicy{Issuers: []string{"https://example.net/"}}, withOtherIntrospectionEndpoint, false},
+		"token endpoint":       {IssuerPolicy{Issuers: []string{"https://token.example.net/"}}, withoutMetadata, true},
+		"domain":               {IssuerPolicy{Domains: []string{"john.example.com"}}, withMetadata, true},
+		"subdomain":            {IssuerPolicy{Domains: []string{".example.com"}}, withMetadata, true},
+		"subdomain not listed": {IssuerPolicy{Domains: []string{"example.com"}}, withMetadata, false},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			assert(tc.policy.Allows(tc.endpoints)).Equal(tc.allowed)
+		})
+	}
+}
+
+// testTokenUser starts a profile page that links to a token endpoint, the token
+// endpoint says that "tokentoken" was issued to the user returned by claims.
+func testTokenUser(claims func() string) (me, tokenEndpoint *httptest.Server) {
+	tokenEndpoint = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tokentoken" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"me": "%s", "scope": "create"}`, claims())
+	}))
+
+	me = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="authorization_endpoint" href="%[1]s/auth" /><link rel="token_endpoint" href="%[1]s/token" />`, tokenEndpoint.URL)
+	}))
+
+	return me, tokenEndpoint
+}
+
+func TestTokenVerifierVerifyClaim(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var me *httptest.Server
+	me, tokenEndpoint := testTokenUser(func() string { return me.URL + "/" })
+	defer me.Close()
+	defer tokenEndpoint.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+	verifier.Policy = &IssuerPolicy{Issuers: []string{tokenEndpoint.URL + "/token"}}
+
+	response, err := verifier.VerifyClaim(context.Background(), "tokentoken", me.URL)
+	assert(err).Must.Nil()
+	assert(response.Me).Equal(me.URL + "/")
+	assert(response.Scopes).Equal([]string{"create"})
+
+	_, err = verifier.VerifyClaim(context.Background(), "what", me.URL)
+	assert(errors.Is(err, ErrInvalidToken)).True()
+}
+
+func TestTokenVerifierVerifyClaimUntrustedIssuer(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var me *httptest.Server
+	me, tokenEndpoint := testTokenUser(func() string { return me.URL + "/" })
+	defer me.Close()
+	defer tokenEndpoint.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+	verifier.Policy = &IssuerPolicy{Issuers: []string{"https://example.com/token"}}
+
+	_, err := verifier.VerifyClaim(context.Background(), "tokentoken", me.URL)
+
+	var oauthErr *OAuthError
+	assert(errors.As(err, &oauthErr)).Must.True()
+	assert(oauthErr.Code).Equal(ErrInvalidToken)
+	assert(oauthErr.Description).Equal("the access token issuer is not trusted")
+}
+
+func TestTokenVerifierVerifyClaimSpoofedIssuer(t *testing.T) {
+	testCases := map[string]struct {
+		issuer        func(attacker string) string
+		tokenEndpoint func(attacker string) string
+	}{
+		"issuer of other server": {
+			issuer:        func(string) string { return "https://trusted.example.com/" },
+			tokenEndpoint: func(attacker string) string { return attacker + "/token" },
+		},
+		"token endpoint on other server": {
+			issuer:        func(attacker string) string { return attacker + "/" },
+			tokenEndpoint: func(string) string { return "https://trusted.example.com/token" },
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			var attacker *httptest.Server
+			attacker = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/metadata":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprintf(w, `{"issuer": "%s", "authorization_endpoint": "%s/auth", "token_endpoint": "%s"}`,
+						tc.issuer(attacker.URL), attacker.URL, tc.tokenEndpoint(attacker.URL))
+				case "/token":
+					w.Header().Set("Content-Type", "application/json")
+					fmt.Fprintf(w, `{"me": "%s/", "scope": "create"}`, attacker.URL)
+				default:
+					w.Header().Set("Content-Type", "text/html")
+					fmt.Fprintf(w, `<link rel="indieauth-metadata" href="%s/metadata" />`, attacker.URL)
+				}
+			}))
+			defer attacker.Close()
+
+			verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+			verifier.Policy = &IssuerPolicy{Issuers: []string{
+				"https://trusted.example.com/",
+				"https://trusted.example.com/token",
+			}}
+
+			_, err := verifier.VerifyClaim(context.Background(), "anything", attacker.URL)
+
+			var oauthErr *OAuthError
+			assert(errors.As(err, &oauthErr)).Must.True()
+			assert(oauthErr.Code).Equal(ErrInvalidToken)
+			assert(oauthErr.Description).Equal("the access token issuer is not trusted")
+		})
+	}
+}
+
+func TestTokenVerifierVerifyClaimForOtherIssuer(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	victim, victimTokenEndpoint := testTokenUser(func() string { return "" })
+	defer victim.Close()
+	defer victimTokenEndpoint.Close()
+
+	// The attacker's token endpoint says the token belongs to the victim.
+	attacker, attackerTokenEndpoint := testTokenUser(func() string { return victim.URL + "/" })
+	defer attacker.Close()
+	defer attackerTokenEndpoint.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+	verifier.Policy = &IssuerPolicy{Domains: []string{"127.0.0.1"}}
+
+	_, err := verifier.VerifyClaim(context.Background(), "tokentoken", attacker.URL)
+
+	var oauthErr *OAuthError
+	assert(errors.As(err, &oauthErr)).Must.True()
+	assert(oauthErr.Code).Equal(ErrInvalidToken)
+	assert(oauthErr.Description).Equal("the access token was not issued for me")
+}
+
+func TestTokenVerifierVerifyClaimForOtherUserOfSharedServer(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var alice *httptest.Server
+
+	// A single token endpoint for both users, which issued the token to alice.
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"me": "%s/", "scope": "create"}`, alice.URL)
+	}))
+	defer tokenEndpoint.Close()
+
+	profile := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="authorization_endpoint" href="%[1]s/auth" /><link rel="token_endpoint" href="%[1]s/token" />`, tokenEndpoint.URL)
+	})
+
+	alice = httptest.NewServer(profile)
+	defer alice.Close()
+
+	bob := httptest.NewServer(profile)
+	defer bob.Close()
+
+	// Only bob, who is reached through localhost, is trusted.
+	bobURL := strings.Replace(bob.URL, "127.0.0.1", "localhost", 1)
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+	verifier.Policy = &IssuerPolicy{Domains: []string{"localhost"}}
+
+	_, err := verifier.VerifyClaim(context.Background(), "tokentoken", bobURL)
+
+	var oauthErr *OAuthError
+	assert(errors.As(err, &oauthErr)).Must.True()
+	assert(oauthErr.Code).Equal(ErrInvalidToken)
+	assert(oauthErr.Description).Equal("the access token was not issued for me")
+}
+
+func TestTokenVerifierHandlerWithPolicyWhenClaimFails(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+	verifier.Policy = &IssuerPolicy{Domains: []string{"localhost"}}
+	verifier.Claim = func(r *http.Request) (string, error) {
+		return "", errors.New("unknown site")
+	}
+
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/?q=config", nil)
+	r.Header.Set("Authorization", "Bearer tokentoken")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert(w.Code).Equal(http.StatusBadRequest)
+	assert(w.Header().Get("WWW-Authenticate")).Equal(`Bearer error="invalid_request", error_description="unknown site"`)
+}
+
+func TestTokenVerifierHandlerWithPolicy(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var me *httptest.Server
+	me, tokenEndpoint := testTokenUser(func() string { return me.URL + "/" })
+	defer me.Close()
+	defer tokenEndpoint.Close()
+
+	verifier := NewTokenVerifier(&Config{}, Endpoints{}, time.Minute)
+	verifier.Policy = &IssuerPolicy{Issuers: []string{tokenEndpoint.URL + "/token"}}
+	verifier.Claim = func(r *http.Request) (string, error) {
+		// Micropub requests don't include "me", so this is found from the
+		// request path as a shared server would.
+		return me.URL + strings.TrimSuffix(r.URL.Path, "/micropub"), nil
+	}
+
+	handler := verifier.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, _ := ResponseFromContext(r.Context())
+		fmt.Fprint(w, response.Me)
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/micropub?q=config", nil)
+	r.Header.Set("Authorization", "Bearer tokentoken")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	assert(w.Code).Equal(http.StatusOK)
+	assert(w.Body.String()).Equal(me.URL + "/")
+}
@@ -0,0 +1,90 @@
+package indieauth
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ProfileURLError is returned when a string is not a valid profile URL.
+type ProfileURLError struct {
+	URL    string
+	Reason string
+}
+
+func (e *ProfileURLError) Error() string {
+	return fmt.Sprintf("invalid profile url %q: %s", e.URL, e.Reason)
+}
+
+// ParseProfileURL parses s as a user's profile URL, applying the rules from
+// https://indieauth.spec.indieweb.org/#user-profile-url. If s has no scheme,
+// such as "example.com", then https is assumed. The returned URL is
+// canonicalized, so it has a lowercase host and a path of at least "/".
+//
+// To allow running a server locally, a port is permitted when the host is
+// "localhost" or a loopback address.
+func ParseProfileURL(s string) (*url.URL, error) {
+	u, _, err := parseProfileURL(s)
+	return u, err
+}
+
+// parseProfileURL is ParseProfileURL but also returns whether the scheme was
+// assumed.
+func parseProfileURL(s string) (u *url.URL, schemeless bool, err error) {
+	invalid := func(reason string) (*url.URL, bool, error) {
+		return nil, false, &ProfileURLError{URL: s, Reason: reason}
+	}
+
+	raw := strings.TrimSpace(s)
+	if raw == "" {
+		return invalid("empty")
+	}
+
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+		schemeless = true
+	}
+
+	u, err = url.Parse(raw)
+	if err != nil {
+		return invalid("could not parse")
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return invalid("scheme must be http or https")
+	}
+	if u.Fragment != "" || strings.Contains(raw, "#") {
+		return invalid("must not contain a fragment")
+	}
+	if u.User != nil {
+		return invalid("must not contain a username or password")
+	}
+	if u.Hostname() == "" {
+		return invalid("must contain a host")
+	}
+	if u.Port() != "" && !isLoopbackHost(u.Hostname()) {
+		return invalid("must not contain a port")
+	}
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return invalid("must not contain dot path segments")
+		}
+	}
+
+	u.Host = strings.ToLower(u.Host)
+	if u.Path == "" {
+		u.Path = "/"
+	}
+
+	return u, schemeless, nil
+}
+
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
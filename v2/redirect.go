@@ -0,0 +1,98 @@
+package indieauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+)
+
+const maxRedirects = 10
+
+// Redirect is a redirect that was followed while discovering endpoints.
+type Redirect struct {
+	From       string
+	To         string
+	StatusCode int
+}
+
+// Permanent returns true if the redirect changes the canonical URL of the
+// resource, that is if it was a 301 or 308.
+func (r Redirect) Permanent() bool {
+	return r.StatusCode == http.StatusMovedPermanently ||
+		r.StatusCode == http.StatusPermanentRedirect
+}
+
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// canonicalURL returns the URL that should be used to identify a resource
+// requested at start, given the redirects that were followed. Permanent
+// redirects change the URL, but once a temporary redirect has been followed
+// any later redirects are ignored.
+func canonicalURL(start string, redirects []Redirect) string {
+	canonical := start
+
+	for _, redirect := range redirects {
+		if !redirect.Permanent() {
+			break
+		}
+
+		canonical = redirect.To
+	}
+
+	return canonical
+}
+
+// get performs a GET request for rawurl following any redirects, each request
+// made goes through the configured Cache and is recorded in report, if not
+// nil. The returned response's Request will contain the final URL.
+func (c *Config) get(ctx context.Context, client *http.Client, rawurl string, limit int64, report *DiscoveryReport) (*http.Response, []Redirect, error) {
+	noFollow := *client
+	noFollow.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var redirects []Redirect
+
+	for {
+		resp, err := c.getOnce(ctx, &noFollow, rawurl, limit)
+		if err != nil {
+			report.fetchFailed(rawurl, err)
+			return nil, redirects, err
+		}
+		report.fetched(rawurl, resp)
+
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			return resp, redirects, nil
+		}
+		resp.Body.Close()
+
+		if len(redirects) >= maxRedirects {
+			return nil, redirects, errors.New("stopped after 10 redirects")
+		}
+
+		next, err := resp.Request.URL.Parse(location)
+		if err != nil {
+			return nil, redirects, err
+		}
+		if next.Scheme != "http" && next.Scheme != "https" {
+			return nil, redirects, &url.Error{Op: "Get", URL: next.String(), Err: errors.New("unsupported redirect scheme")}
+		}
+
+		redirects = append(redirects, Redirect{
+			From:       rawurl,
+			To:         next.String(),
+			StatusCode: resp.StatusCode,
+		})
+		rawurl = next.String()
+	}
+}
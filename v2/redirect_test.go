@@ -0,0 +1,128 @@
+package indieauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func testRedirectServer(code int, to string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, to, code)
+	}))
+}
+
+func TestFindEndpointsPermanentRedirect(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`<link rel="authorization_endpoint" href="/auth" />`, nil)
+	defer homepage.Close()
+
+	moved := testRedirectServer(http.StatusMovedPermanently, homepage.URL)
+	defer moved.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(moved.URL)
+	assert(err).Must.Nil()
+
+	assert(endpoints.Me).Equal(homepage.URL + "/")
+	assert(endpoints.Authorization.String()).Equal(homepage.URL + "/auth")
+	assert(endpoints.Redirects).Equal([]Redirect{
+		{From: moved.URL + "/", To: homepage.URL, StatusCode: http.StatusMovedPermanently},
+	})
+}
+
+func TestFindEndpointsTemporaryRedirect(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`<link rel="authorization_endpoint" href="/auth" />`, nil)
+	defer homepage.Close()
+
+	found := testRedirectServer(http.StatusFound, homepage.URL)
+	defer found.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(found.URL)
+	assert(err).Must.Nil()
+
+	assert(endpoints.Me).Equal(found.URL + "/")
+	assert(endpoints.Authorization.String()).Equal(homepage.URL + "/auth")
+	assert(endpoints.Redirects).Len(1)
+}
+
+func TestFindEndpointsPermanentAfterTemporaryRedirect(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`<link rel="authorization_endpoint" href="/auth" />`, nil)
+	defer homepage.Close()
+
+	moved := testRedirectServer(http.StatusPermanentRedirect, homepage.URL)
+	defer moved.Close()
+
+	found := testRedirectServer(http.StatusTemporaryRedirect, moved.URL)
+	defer found.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(found.URL)
+	assert(err).Must.Nil()
+
+	assert(endpoints.Me).Equal(found.URL + "/")
+	assert(endpoints.Redirects).Len(2)
+}
+
+func TestFindEndpointsRedirectLoop(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var loop *httptest.Server
+	loop = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, loop.URL, http.StatusFound)
+	}))
+	defer loop.Close()
+
+	_, err := (&Config{}).FindEndpoints(loop.URL)
+	assert(err).NotNil()
+}
+
+func TestExchangeCanonicalMe(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var moved *httptest.Server
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"me": "%s"}`, moved.URL)
+	}))
+	defer auth.Close()
+
+	homepage := testEndpointServer(`<link rel="authorization_endpoint" href="`+auth.URL+`" />`, nil)
+	defer homepage.Close()
+
+	moved = testRedirectServer(http.StatusMovedPermanently, homepage.URL)
+	defer moved.Close()
+
+	config := &Config{
+		ClientID:    "http://localhost",
+		RedirectURL: "http://localhost/callback",
+	}
+
+	response, err := config.Exchange(Endpoints{Authorization: urlParse(auth.URL)}, "verifier", "abcde")
+	assert(err).Must.Nil()
+	assert(response.Me).Equal(homepage.URL + "/")
+}
+
+func TestCanonicalURL(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	assert(canonicalURL("http://a/", nil)).Equal("http://a/")
+
+	assert(canonicalURL("http://a/", []Redirect{
+		{From: "http://a/", To: "http://b/", StatusCode: http.StatusMovedPermanently},
+		{From: "http://b/", To: "http://c/", StatusCode: http.StatusPermanentRedirect},
+	})).Equal("http://c/")
+
+	assert(canonicalURL("http://a/", []Redirect{
+		{From: "http://a/", To: "http://b/", StatusCode: http.StatusMovedPermanently},
+		{From: "http://b/", To: "http://c/", StatusCode: http.StatusFound},
+		{From: "http://c/", To: "http://d/", StatusCode: http.StatusMovedPermanently},
+	})).Equal("http://b/")
+}
@@ -0,0 +1,51 @@
+package indieauth
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Refresh uses a refresh token to obtain a new access token from the token
+// endpoint. If scopes are given they are requested, to narrow the scope of the
+// new access token, otherwise the new token will have the same scope as the
+// original.
+//
+// The returned Response may contain a new refresh token, which should replace
+// the one used.
+func (c *Config) Refresh(endpoints Endpoints, refreshToken string, scopes ...string) (*Response, error) {
+	return c.RefreshContext(context.Background(), endpoints, refreshToken, scopes...)
+}
+
+// RefreshContext is like Refresh but uses ctx for the requests made.
+func (c *Config) RefreshContext(ctx context.Context, endpoints Endpoints, refreshToken string, scopes ...string) (*Response, error) {
+	if endpoints.Token == nil {
+		return nil, ErrTokenEndpointMissing
+	}
+
+	ctx, cancel := withTimeout(ctx, c.ExchangeTimeout)
+	defer cancel()
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {c.ClientID},
+	}
+
+	if len(scopes) > 0 {
+		form.Set("scope", strings.Join(scopes, " "))
+	}
+
+	data, err := c.postForm(ctx, endpoints.Token, form)
+	if err != nil {
+		return nil, err
+	}
+
+	response := data.response(time.Now())
+	if data.Scope == "" && len(scopes) > 0 {
+		response.Scopes = scopes
+	}
+
+	return response, nil
+}
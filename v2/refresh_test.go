@@ -0,0 +1,88 @@
+package indieauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+type testRefreshEndpoint struct {
+	t             *testing.T
+	expectedScope string
+	scope         string
+}
+
+func (e *testRefreshEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	assert := assert.Wrap(e.t)
+
+	ok := assert(r.Method).Equal("POST") &&
+		assert(r.Header.Get("Content-Type")).Equal("application/x-www-form-urlencoded") &&
+		assert(r.FormValue("grant_type")).Equal("refresh_token") &&
+		assert(r.FormValue("refresh_token")).Equal("refreshrefresh") &&
+		assert(r.FormValue("client_id")).Equal("http://localhost") &&
+		assert(r.FormValue("scope")).Equal(e.expectedScope)
+
+	if !ok {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{
+  "access_token": "newtoken",
+  "token_type": "Bearer",
+  "scope": "%s",
+  "me": "https://me.example.com/",
+  "refresh_token": "newrefresh",
+  "expires_in": 3600
+}`, e.scope)
+}
+
+func TestRefresh(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	te := &testRefreshEndpoint{t: t, scope: "create update"}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	config := &Config{ClientID: "http://localhost"}
+
+	before := time.Now()
+	response, err := config.Refresh(Endpoints{Token: urlParse(ts.URL)}, "refreshrefresh")
+	assert(err).Must.Nil()
+
+	assert(response.AccessToken).Equal("newtoken")
+	assert(response.TokenType).Equal("Bearer")
+	assert(response.Scopes).Equal([]string{"create", "update"})
+	assert(response.Me).Equal("https://me.example.com/")
+	assert(response.RefreshToken).Equal("newrefresh")
+	assert(response.ExpiresIn).Equal(int64(3600))
+	assert(response.Expiry.Before(before.Add(time.Hour))).False()
+	assert(response.Expiry.After(time.Now().Add(time.Hour))).False()
+}
+
+func TestRefreshNarrowScope(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	te := &testRefreshEndpoint{t: t, expectedScope: "create"}
+	ts := httptest.NewServer(te)
+	defer ts.Close()
+
+	config := &Config{ClientID: "http://localhost"}
+
+	response, err := config.Refresh(Endpoints{Token: urlParse(ts.URL)}, "refreshrefresh", "create")
+	assert(err).Must.Nil()
+
+	assert(response.Scopes).Equal([]string{"create"})
+}
+
+func TestRefreshWithoutTokenEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	_, err := (&Config{}).Refresh(Endpoints{}, "refreshrefresh")
+	assert(err).Equal(ErrTokenEndpointMissing)
+}
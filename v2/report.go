@@ -0,0 +1,195 @@
+package indieauth
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/tomnomnom/linkheader"
+)
+
+// A DiscoveryReport records each step taken while discovering the endpoints
+// for a profile URL. It is intended to help users understand why signing in
+// failed, so that they can fix the markup on their site.
+type DiscoveryReport struct {
+	// Me is the profile URL that discovery was started with.
+	Me string
+
+	// Fetches lists each request made, in order, including those for redirects
+	// and the metadata document.
+	Fetches []FetchReport
+
+	// UsedMetadata is true if the endpoints were read from a metadata document.
+	UsedMetadata bool
+
+	// Candidates lists each link that could have provided an endpoint, and
+	// whether it was used.
+	Candidates []Candidate
+
+	// Err is the error discovery finished with, if any.
+	Err error
+}
+
+// FetchReport describes a single request made during discovery.
+type FetchReport struct {
+	URL         string
+	StatusCode  int
+	ContentType string
+
+	// LinkHeaders are the raw values of any Link headers in the response.
+	LinkHeaders []string
+
+	// HTMLLinks are the <link> elements read from the response body.
+	HTMLLinks []FoundLink
+
+	// Err is set if the request failed.
+	Err error
+}
+
+// FoundLink is a rel and URL pair found in a response.
+type FoundLink struct {
+	Rel string
+	URL string
+}
+
+// Candidate sources.
+const (
+	SourceLinkHeader = "Link header"
+	SourceHTML       = "HTML"
+	SourceMetadata   = "metadata"
+)
+
+// Candidate is a link that could have provided an endpoint.
+type Candidate struct {
+	Rel      string
+	URL      string
+	Source   string
+	Accepted bool
+	Reason   string
+}
+
+// DiagnoseEndpoints is like FindEndpointsContext, but also returns a report of
+// the steps taken. The report is returned even when discovery fails.
+func (c *Config) DiagnoseEndpoints(ctx context.Context, me string) (Endpoints, *DiscoveryReport, error) {
+	report := &DiscoveryReport{Me: me}
+
+	endpoints, err := c.findEndpoints(ctx, me, report)
+	report.Err = err
+
+	return endpoints, report, err
+}
+
+func (r *DiscoveryReport) fetched(url string, resp *http.Response) {
+	if r == nil {
+		return
+	}
+
+	mediatype, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	r.Fetches = append(r.Fetches, FetchReport{
+		URL:         url,
+		StatusCode:  resp.StatusCode,
+		ContentType: mediatype,
+		LinkHeaders: resp.Header["Link"],
+	})
+}
+
+func (r *DiscoveryReport) fetchFailed(url string, err error) {
+	if r == nil {
+		return
+	}
+
+	r.Fetches = append(r.Fetches, FetchReport{
+		URL: url,
+		Err: err,
+	})
+}
+
+func (r *DiscoveryReport) foundHTMLLinks(links linkheader.Links) {
+	if r == nil || len(r.Fetches) == 0 {
+		return
+	}
+
+	last := &r.Fetches[len(r.Fetches)-1]
+	for _, link := range links {
+		last.HTMLLinks = append(last.HTMLLinks, FoundLink{Rel: link.Rel, URL: link.URL})
+	}
+}
+
+func (r *DiscoveryReport) usedMetadata() {
+	if r != nil {
+		r.UsedMetadata = true
+	}
+}
+
+func (r *DiscoveryReport) accept(rel, url, source, reason string) {
+	if r != nil {
+		r.Candidates = append(r.Candidates, Candidate{
+			Rel:      rel,
+			URL:      url,
+			Source:   source,
+			Accepted: true,
+			Reason:   reason,
+		})
+	}
+}
+
+func (r *DiscoveryReport) reject(rel, url, source, reason string) {
+	if r != nil {
+		r.Candidates = append(r.Candidates, Candidate{
+			Rel:    rel,
+			URL:    url,
+			Source: source,
+			Reason: reason,
+		})
+	}
+}
+
+// String formats the report as text suitable for showing to a user.
+func (r *DiscoveryReport) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "Discovery for %s\n", r.Me)
+
+	for _, fetch := range r.Fetches {
+		if fetch.Err != nil {
+			fmt.Fprintf(&b, "\nGET %s\n  failed: %v\n", fetch.URL, fetch.Err)
+			continue
+		}
+
+		fmt.Fprintf(&b, "\nGET %s\n  status: %d\n", fetch.URL, fetch.StatusCode)
+		if fetch.ContentType != "" {
+			fmt.Fprintf(&b, "  content-type: %s\n", fetch.ContentType)
+		}
+		for _, header := range fetch.LinkHeaders {
+			fmt.Fprintf(&b, "  Link: %s\n", header)
+		}
+		for _, link := range fetch.HTMLLinks {
+			fmt.Fprintf(&b, "  <link rel=%q href=%q>\n", link.Rel, link.URL)
+		}
+	}
+
+	if r.UsedMetadata {
+		b.WriteString("\nEndpoints were read from the metadata document.\n")
+	}
+
+	if len(r.Candidates) > 0 {
+		b.WriteString("\nCandidates:\n")
+	}
+	for _, candidate := range r.Candidates {
+		status := "rejected"
+		if candidate.Accepted {
+			status = "accepted"
+		}
+
+		fmt.Fprintf(&b, "  %s %s (%s): %s, %s\n", candidate.Rel, candidate.URL, candidate.Source, status, candidate.Reason)
+	}
+
+	if r.Err != nil {
+		fmt.Fprintf(&b, "\nError: %v\n", r.Err)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package indieauth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestDiagnoseEndpoints(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`
+<html>
+<head>
+<link rel="authorization_endpoint" href="http://example.com/hey2" />
+<link rel="token_endpoint" href="http://example.com/what" />
+</head>
+</html>
+`, http.Header{
+		"Link": {`<http://example.com/hey>; rel="authorization_endpoint"`},
+	})
+	defer homepage.Close()
+
+	_, report, err := (&Config{}).DiagnoseEndpoints(context.Background(), homepage.URL)
+	assert(err).Must.Nil()
+
+	assert(report.Me).Equal(homepage.URL)
+	assert(report.UsedMetadata).False()
+	assert(report.Err).Nil()
+
+	assert(report.Fetches).Must.Len(1)
+	fetch := report.Fetches[0]
+	assert(fetch.URL).Equal(homepage.URL + "/")
+	assert(fetch.StatusCode).Equal(http.StatusOK)
+	assert(fetch.ContentType).Equal("text/html")
+	assert(fetch.LinkHeaders).Equal([]string{`<http://example.com/hey>; rel="authorization_endpoint"`})
+	assert(fetch.HTMLLinks).Equal([]FoundLink{
+		{Rel: "authorization_endpoint", URL: "http://example.com/hey2"},
+		{Rel: "token_endpoint", URL: "http://example.com/what"},
+	})
+
+	assert(report.Candidates).Equal([]Candidate{
+		{Rel: "authorization_endpoint", URL: "http://example.com/hey", Source: SourceLinkHeader, Accepted: true, Reason: "first authorization_endpoint link"},
+		{Rel: "authorization_endpoint", URL: "http://example.com/hey2", Source: SourceHTML, Reason: "an earlier authorization_endpoint link is used"},
+		{Rel: "token_endpoint", URL: "http://example.com/what", Source: SourceHTML, Accepted: true, Reason: "first token_endpoint link"},
+	})
+}
+
+func TestDiagnoseEndpointsViaMetadata(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testMetadataEndpoint(`{"authorization_endpoint": "http://example.com/auth"}`)
+	defer metadata.Close()
+
+	homepage := testEndpointServer(`
+<link rel="indieauth-metadata" href="`+metadata.URL+`" />
+<link rel="authorization_endpoint" href="http://example.com/hey" />
+`, nil)
+	defer homepage.Close()
+
+	_, report, err := (&Config{}).DiagnoseEndpoints(context.Background(), homepage.URL)
+	assert(err).Must.Nil()
+
+	assert(report.UsedMetadata).True()
+	assert(report.Fetches).Must.Len(2)
+	assert(report.Fetches[1].URL).Equal(metadata.URL)
+	assert(report.Fetches[1].ContentType).Equal("application/json")
+
+	assert(report.Candidates).Equal([]Candidate{
+		{Rel: "indieauth-metadata", URL: metadata.URL, Source: SourceHTML, Accepted: true, Reason: "first indieauth-metadata link"},
+		{Rel: "authorization_endpoint", URL: "http://example.com/hey", Source: SourceHTML, Reason: "indieauth-metadata takes precedence"},
+		{Rel: "authorization_endpoint", URL: "http://example.com/auth", Source: SourceMetadata, Accepted: true, Reason: "read from metadata document"},
+		{Rel: "token_endpoint", URL: "", Source: SourceMetadata, Reason: "missing from metadata document"},
+	})
+}
+
+func TestDiagnoseEndpointsFailure(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	homepage := testEndpointServer(`<html><head></head></html>`, nil)
+	defer homepage.Close()
+
+	moved := testRedirectServer(http.StatusMovedPermanently, homepage.URL)
+	defer moved.Close()
+
+	_, report, err := (&Config{}).DiagnoseEndpoints(context.Background(), moved.URL)
+	assert(err).Equal(ErrAuthorizationEndpointMissing)
+	assert(report.Err).Equal(ErrAuthorizationEndpointMissing)
+
+	assert(report.Fetches).Must.Len(2)
+	assert(report.Fetches[0].StatusCode).Equal(http.StatusMovedPermanently)
+	assert(report.Fetches[1].StatusCode).Equal(http.StatusOK)
+	assert(report.Candidates).Len(0)
+
+	s := report.String()
+	assert(strings.Contains(s, "GET "+moved.URL+"/\n  status: 301")).True()
+	assert(strings.Contains(s, "Error: no authorization endpoint found")).True()
+}
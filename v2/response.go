@@ -1,11 +1,25 @@
 package indieauth
 
+import (
+	"time"
+)
+
 type Response struct {
 	AccessToken string
 	TokenType   string
 	Scopes      []string
 	Me          string
-	Profile     map[string]interface{}
+	Profile     *Profile
+
+	// RefreshToken, if set, can be used with Config.Refresh to obtain a new
+	// access token.
+	RefreshToken string
+
+	// ExpiresIn is the lifetime in seconds of the access token, as returned by
+	// the token endpoint. Expiry is the time that this lifetime ends, it will be
+	// zero if the token endpoint did not give a lifetime.
+	ExpiresIn int64
+	Expiry    time.Time
 }
 
 // HasScope returns true if the Response was issued with the scope.
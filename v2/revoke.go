@@ -0,0 +1,41 @@
+package indieauth
+
+import (
+	"context"
+	"net/url"
+)
+
+// Revoke revokes token, which may be an access token or refresh token. If the
+// endpoints were discovered using a metadata document that defines a
+// revocation endpoint it is used, as in RFC 7009. Otherwise the legacy method
+// of making a request to the token endpoint with "action=revoke" is used.
+func (c *Config) Revoke(endpoints Endpoints, token string) error {
+	return c.RevokeContext(context.Background(), endpoints, token)
+}
+
+// RevokeContext is like Revoke but uses ctx for the requests made.
+func (c *Config) RevokeContext(ctx context.Context, endpoints Endpoints, token string) error {
+	ctx, cancel := withTimeout(ctx, c.ExchangeTimeout)
+	defer cancel()
+
+	form := url.Values{
+		"token": {token},
+	}
+
+	endpoint := endpoints.Revocation
+	if endpoint == nil {
+		if endpoints.Token == nil {
+			return ErrTokenEndpointMissing
+		}
+
+		endpoint = endpoints.Token
+		form.Set("action", "revoke")
+	}
+
+	resp, err := c.post(ctx, endpoint, form)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
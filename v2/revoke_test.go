@@ -0,0 +1,134 @@
+package indieauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+type testRevocationEndpoint struct {
+	tokens  []string
+	actions []string
+}
+
+func (e *testRevocationEndpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		http.Error(w, "", http.StatusBadRequest)
+		return
+	}
+
+	e.tokens = append(e.tokens, r.FormValue("token"))
+	e.actions = append(e.actions, r.FormValue("action"))
+}
+
+func TestRevoke(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	revocation := &testRevocationEndpoint{}
+	rs := httptest.NewServer(revocation)
+	defer rs.Close()
+
+	token := &testRevocationEndpoint{}
+	ts := httptest.NewServer(token)
+	defer ts.Close()
+
+	err := (&Config{}).Revoke(Endpoints{
+		Token:      urlParse(ts.URL),
+		Revocation: urlParse(rs.URL),
+	}, "tokentoken")
+	assert(err).Must.Nil()
+
+	assert(revocation.tokens).Equal([]string{"tokentoken"})
+	assert(revocation.actions).Equal([]string{""})
+	assert(token.tokens).Len(0)
+}
+
+func TestRevokeLegacy(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	token := &testRevocationEndpoint{}
+	ts := httptest.NewServer(token)
+	defer ts.Close()
+
+	err := (&Config{}).Revoke(Endpoints{
+		Token: urlParse(ts.URL),
+	}, "tokentoken")
+	assert(err).Must.Nil()
+
+	assert(token.tokens).Equal([]string{"tokentoken"})
+	assert(token.actions).Equal([]string{"revoke"})
+}
+
+func TestRevokeError(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	err := (&Config{}).Revoke(Endpoints{Token: urlParse(ts.URL)}, "tokentoken")
+
+	if requestErr, ok := err.(*RequestError); assert(ok).True() {
+		assert(requestErr.StatusCode).Equal(http.StatusServiceUnavailable)
+	}
+}
+
+func TestRevokeWithoutEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	err := (&Config{}).Revoke(Endpoints{}, "tokentoken")
+	assert(err).Equal(ErrTokenEndpointMissing)
+}
+
+func TestFindEndpointsRevocationViaMetadata(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testMetadataEndpoint(`{"authorization_endpoint": "/auth", "revocation_endpoint": "/revoke"}`)
+	defer metadata.Close()
+
+	homepage := testEndpointServer(`<link rel="indieauth-metadata" href="`+metadata.URL+`" />`, nil)
+	defer homepage.Close()
+
+	endpoints, err := (&Config{}).FindEndpoints(homepage.URL)
+	assert(err).Must.Nil()
+	assert(endpoints.Revocation.String()).Equal(metadata.URL + "/revoke")
+}
+
+func TestSessionsSignOutRevokes(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	revocation := &testRevocationEndpoint{}
+	rs := httptest.NewServer(revocation)
+	defer rs.Close()
+
+	sessions, err := NewSessions("KA==", &Config{})
+	assert(err).Must.Nil()
+	sessions.RevokeOnSignOut = true
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	err = sessions.set(w, r, &Response{
+		Me:           "https://me.example.com/",
+		AccessToken:  "tokentoken",
+		RefreshToken: "refreshrefresh",
+	}, Endpoints{
+		Authorization: urlParse("https://auth.example.com/"),
+		Revocation:    urlParse(rs.URL),
+	})
+	assert(err).Must.Nil()
+
+	r, _ = http.NewRequest(http.MethodGet, "/sign-out", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	err = sessions.SignOut(w, r)
+	assert(err).Must.Nil()
+
+	assert(revocation.tokens).Equal([]string{"refreshrefresh", "tokentoken"})
+}
@@ -0,0 +1,55 @@
+package indieauth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// RequireScope returns a function that wraps a handler so that it is only
+// called for requests that have a Response, as stored by ContextWithResponse,
+// with all of scopes. Requests that are missing a scope are answered with a 403
+// insufficient_scope error, and requests without a Response with a 401.
+//
+// It can be used with TokenVerifier.Handler for requests using access tokens,
+// or Sessions.Handler for requests from a browser.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return requireScope(scopes, func(response *Response) bool {
+		for _, scope := range scopes {
+			if !response.HasScope(scope) {
+				return false
+			}
+		}
+
+		return true
+	}, "the access token requires the scopes: %s")
+}
+
+// RequireAnyScope is like RequireScope, but only one of scopes is required.
+func RequireAnyScope(scopes ...string) func(http.Handler) http.Handler {
+	return requireScope(scopes, func(response *Response) bool {
+		return hasAnyScope(response, scopes)
+	}, "the access token requires one of the scopes: %s")
+}
+
+func requireScope(scopes []string, allowed func(*Response) bool, description string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			response, ok := ResponseFromContext(r.Context())
+			if !ok {
+				writeBearerError(w, "", nil, nil)
+				return
+			}
+
+			if !allowed(response) {
+				writeBearerError(w, "", &OAuthError{
+					Code:        ErrInsufficientScope,
+					Description: fmt.Sprintf(description, strings.Join(scopes, " ")),
+				}, scopes)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
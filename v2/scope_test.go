@@ -0,0 +1,92 @@
+package indieauth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestRequireScope(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	testCases := map[string]struct {
+		handler      http.Handler
+		response     *Response
+		status       int
+		authenticate string
+	}{
+		"all present": {
+			handler:  RequireScope("create", "update")(ok),
+			response: &Response{Me: "https://me.example.com/", Scopes: []string{"create", "update"}},
+			status:   http.StatusOK,
+		},
+		"one missing": {
+			handler:      RequireScope("create", "delete")(ok),
+			response:     &Response{Me: "https://me.example.com/", Scopes: []string{"create", "update"}},
+			status:       http.StatusForbidden,
+			authenticate: `Bearer error="insufficient_scope", error_description="the access token requires the scopes: create delete", scope="create delete"`,
+		},
+		"any present": {
+			handler:  RequireAnyScope("update", "delete")(ok),
+			response: &Response{Me: "https://me.example.com/", Scopes: []string{"create", "update"}},
+			status:   http.StatusOK,
+		},
+		"any missing": {
+			handler:      RequireAnyScope("update", "delete")(ok),
+			response:     &Response{Me: "https://me.example.com/", Scopes: []string{"create"}},
+			status:       http.StatusForbidden,
+			authenticate: `Bearer error="insufficient_scope", error_description="the access token requires one of the scopes: update delete", scope="update delete"`,
+		},
+		"no response": {
+			handler:      RequireScope("create")(ok),
+			status:       http.StatusUnauthorized,
+			authenticate: "Bearer",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.response != nil {
+				r = r.WithContext(ContextWithResponse(r.Context(), tc.response))
+			}
+
+			w := httptest.NewRecorder()
+			tc.handler.ServeHTTP(w, r)
+
+			assert(w.Code).Equal(tc.status)
+			assert(w.Header().Get("WWW-Authenticate")).Equal(tc.authenticate)
+		})
+	}
+}
+
+func TestRequireScopeWithSessions(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	sessions, err := NewSessions("KA==", &Config{})
+	assert(err).Must.Nil()
+
+	handler := sessions.Handler(RequireScope("create")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert(w.Code).Equal(http.StatusUnauthorized)
+
+	w = httptest.NewRecorder()
+	sessions.set(w, httptest.NewRequest(http.MethodGet, "/", nil), &Response{Me: "https://me.example.com/", Scopes: []string{"create"}}, Endpoints{})
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	assert(w.Code).Equal(http.StatusOK)
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"hawx.me/code/indieauth/v2"
+)
+
+// AuthorizationRequest is a valid request made to the authorization endpoint.
+type AuthorizationRequest struct {
+	ClientID      string
+	RedirectURI   string
+	State         string
+	CodeChallenge string
+
+	// Scopes are the scopes requested by the client, they may be empty if the
+	// client only wants to know the profile URL of the user.
+	Scopes []string
+
+	// Me is the profile URL that the user entered into the client, it is only a
+	// hint and may be empty.
+	Me string
+}
+
+// Approval is what the owner approved for an AuthorizationRequest.
+type Approval struct {
+	// Me is the profile URL of the owner, it must be a valid profile URL.
+	Me string
+
+	// Scopes are the scopes to grant, which may be fewer than those requested
+	// but must not include any that were not requested.
+	Scopes []string
+
+	// Profile, if set, is returned to the client when the "profile" scope is
+	// granted. The Email is only returned when the "email" scope is granted.
+	Profile *indieauth.Profile
+}
+
+// ConsentFunc is called for each valid AuthorizationRequest. It should check
+// that the owner is signed in and ask them to approve the request, for example
+// by writing a form that submits the same parameters back to the authorization
+// endpoint.
+//
+// If the request is approved an Approval should be returned, and the user will
+// be redirected back to the client with a code. If nil is returned for both the
+// Approval and error then a response has been written, and nothing more is
+// done. If an error is returned the user is redirected back to the client with
+// it, an *indieauth.OAuthError can be used to give a specific error code, such
+// as indieauth.ErrAccessDenied; any other error is returned as server_error. An
+// invalid Approval is also returned as server_error.
+type ConsentFunc func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error)
+
+// AuthorizationEndpoint returns the handler for the authorization endpoint. It
+// validates requests, passes them to the ConsentFunc, then redirects back to
+// the client with "code", "state" and "iss".
+//
+// POST requests with a "grant_type" redeem a code for the profile URL and
+// profile information only, as is done by clients that do not need an access
+// token.
+func (s *Server) AuthorizationEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.PostFormValue("grant_type") != "" {
+			s.redeemProfile(w, r)
+			return
+		}
+
+		clientID := r.FormValue("client_id")
+		if !validClientID(clientID) {
+			http.Error(w, "invalid client_id", http.StatusBadRequest)
+			return
+		}
+
+		redirectURI := r.FormValue("redirect_uri")
+		if !validRedirectURI(clientID, redirectURI) {
+			http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+			return
+		}
+
+		// From this point errors can be sent back to the client.
+		state := r.FormValue("state")
+		redirectError := func(code indieauth.ErrorCode, description string) {
+			s.redirect(w, r, redirectURI, url.Values{
+				"error":             {string(code)},
+				"error_description": {description},
+				"state":             {state},
+			})
+		}
+
+		if r.FormValue("response_type") != "code" {
+			redirectError(indieauth.ErrUnsupportedResponseType, "response_type must be code")
+			return
+		}
+		if state == "" {
+			redirectError(indieauth.ErrInvalidRequest, "missing state")
+			return
+		}
+		if r.FormValue("code_challenge") == "" {
+			redirectError(indieauth.ErrInvalidRequest, "missing code_challenge")
+			return
+		}
+		if r.FormValue("code_challenge_method") != "S256" {
+			redirectError(indieauth.ErrInvalidRequest, "code_challenge_method must be S256")
+			return
+		}
+
+		req := &AuthorizationRequest{
+			ClientID:      clientID,
+			RedirectURI:   redirectURI,
+			State:         state,
+			CodeChallenge: r.FormValue("code_challenge"),
+			Scopes:        strings.Fields(r.FormValue("scope")),
+			Me:            r.FormValue("me"),
+		}
+
+		approval, err := s.consent(w, r, req)
+		if err != nil {
+			var oauthErr *indieauth.OAuthError
+			if errors.As(err, &oauthErr) {
+				redirectError(oauthErr.Code, oauthErr.Description)
+			} else {
+				redirectError(indieauth.ErrServerError, "")
+			}
+			return
+		}
+		if approval == nil {
+			return
+		}
+		if !validApproval(req, approval) {
+			redirectError(indieauth.ErrServerError, "")
+			return
+		}
+
+		code, err := s.issue(&grant{
+			clientID:      req.ClientID,
+			redirectURI:   req.RedirectURI,
+			codeChallenge: req.CodeChallenge,
+			me:            approval.Me,
+			scopes:        approval.Scopes,
+			profile:       approval.Profile,
+		})
+		if err != nil {
+			redirectError(indieauth.ErrServerError, "")
+			return
+		}
+
+		s.redirect(w, r, redirectURI, url.Values{
+			"code":  {code},
+			"state": {state},
+		})
+	}
+}
+
+// redirect sends the user back to redirectURI with params, and the issuer.
+// Empty params are not included.
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values) {
+	u, _ := url.Parse(redirectURI)
+
+	query := u.Query()
+	for key, values := range params {
+		if len(values) > 0 && values[0] != "" {
+			query.Set(key, values[0])
+		}
+	}
+	if s.metadata.Issuer != "" {
+		query.Set("iss", s.metadata.Issuer)
+	}
+	u.RawQuery = query.Encode()
+
+	http.Redirect(w, r, u.String(), http.StatusFound)
+}
+
+// validApproval returns true if approval is for a valid profile URL, and only
+// grants scopes that were requested in req.
+func validApproval(req *AuthorizationRequest, approval *Approval) bool {
+	if _, err := indieauth.ParseProfileURL(approval.Me); err != nil {
+		return false
+	}
+
+	for _, scope := range approval.Scopes {
+		if !contains(req.Scopes, scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// validClientID checks clientID against the rules in
+// https://indieauth.spec.indieweb.org/#client-identifier.
+func validClientID(clientID string) bool {
+	u, err := url.Parse(clientID)
+	if err != nil {
+		return false
+	}
+
+	return (u.Scheme == "https" || u.Scheme == "http") &&
+		u.Host != "" &&
+		u.User == nil &&
+		u.Fragment == "" &&
+		!strings.Contains("/"+u.Path+"/", "/./") &&
+		!strings.Contains("/"+u.Path+"/", "/../")
+}
+
+// validRedirectURI returns true if redirectURI is on the same scheme, host and
+// port as clientID. Redirect URIs published by the client are not fetched, so
+// any other redirect URI is rejected.
+func validRedirectURI(clientID, redirectURI string) bool {
+	client, err := url.Parse(clientID)
+	if err != nil {
+		return false
+	}
+
+	redirect, err := url.Parse(redirectURI)
+	if err != nil {
+		return false
+	}
+
+	return redirect.Scheme == client.Scheme &&
+		strings.EqualFold(redirect.Host, client.Host) &&
+		redirect.Fragment == ""
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/indieauth/v2"
+)
+
+func testServer(consent ConsentFunc) *Server {
+	return New(indieauth.Metadata{
+		Issuer:                "https://auth.example.com/",
+		AuthorizationEndpoint: "https://auth.example.com/auth",
+		TokenEndpoint:         "https://auth.example.com/token",
+	}, consent)
+}
+
+func approve(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+	return &Approval{Me: "https://me.example.com/", Scopes: req.Scopes}, nil
+}
+
+func authorizationRequest(params url.Values) *http.Request {
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {"https://app.example.com/"},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"state":                 {"1234"},
+		"code_challenge":        {"challenge"},
+		"code_challenge_method": {"S256"},
+		"scope":                 {"create update"},
+		"me":                    {"https://me.example.com/"},
+	}
+	for key, values := range params {
+		query[key] = values
+	}
+
+	return httptest.NewRequest(http.MethodGet, "/auth?"+query.Encode(), nil)
+}
+
+func TestAuthorizationEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var received *AuthorizationRequest
+	s := testServer(func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+		received = req
+		return &Approval{Me: "https://me.example.com/", Scopes: []string{"create"}}, nil
+	})
+
+	w := httptest.NewRecorder()
+	s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(nil))
+
+	assert(w.Code).Equal(http.StatusFound)
+	assert(received).Equal(&AuthorizationRequest{
+		ClientID:      "https://app.example.com/",
+		RedirectURI:   "https://app.example.com/callback",
+		State:         "1234",
+		CodeChallenge: "challenge",
+		Scopes:        []string{"create", "update"},
+		Me:            "https://me.example.com/",
+	})
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	assert(err).Must.Nil()
+	assert(location.Host).Equal("app.example.com")
+	assert(location.Path).Equal("/callback")
+
+	query := location.Query()
+	assert(query.Get("state")).Equal("1234")
+	assert(query.Get("iss")).Equal("https://auth.example.com/")
+
+	g, ok := s.grants[query.Get("code")]
+	assert(ok).Must.True()
+	assert(g.clientID).Equal("https://app.example.com/")
+	assert(g.redirectURI).Equal("https://app.example.com/callback")
+	assert(g.codeChallenge).Equal("challenge")
+	assert(g.me).Equal("https://me.example.com/")
+	assert(g.scopes).Equal([]string{"create"})
+}
+
+func TestAuthorizationEndpointInvalidClient(t *testing.T) {
+	testCases := map[string]url.Values{
+		"missing client_id":          {"client_id": {""}},
+		"client_id without scheme":   {"client_id": {"app.example.com"}},
+		"client_id with fragment":    {"client_id": {"https://app.example.com/#a"}},
+		"client_id with dot path":    {"client_id": {"https://app.example.com/../"}},
+		"redirect_uri on other host": {"redirect_uri": {"https://evil.example.com/callback"}},
+		"missing redirect_uri":       {"redirect_uri": {""}},
+	}
+
+	for name, params := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			s := testServer(approve)
+
+			w := httptest.NewRecorder()
+			s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(params))
+
+			assert(w.Code).Equal(http.StatusBadRequest)
+			assert(w.Header().Get("Location")).Equal("")
+		})
+	}
+}
+
+func TestAuthorizationEndpointInvalidRequest(t *testing.T) {
+	testCases := map[string]struct {
+		params url.Values
+		err    string
+		state  string
+	}{
+		"response_type": {
+			params: url.Values{"response_type": {"token"}},
+			err:    "unsupported_response_type",
+			state:  "1234",
+		},
+		"missing state": {
+			params: url.Values{"state": {""}},
+			err:    "invalid_request",
+		},
+		"missing code_challenge": {
+			params: url.Values{"code_challenge": {""}},
+			err:    "invalid_request",
+			state:  "1234",
+		},
+		"plain code_challenge_method": {
+			params: url.Values{"code_challenge_method": {"plain"}},
+			err:    "invalid_request",
+			state:  "1234",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			s := testServer(approve)
+
+			w := httptest.NewRecorder()
+			s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(tc.params))
+
+			assert(w.Code).Equal(http.StatusFound)
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			assert(err).Must.Nil()
+
+			query := location.Query()
+			assert(query.Get("error")).Equal(tc.err)
+			assert(query.Get("state")).Equal(tc.state)
+			assert(query.Get("iss")).Equal("https://auth.example.com/")
+			assert(query.Get("code")).Equal("")
+			assert(s.grants).Len(0)
+		})
+	}
+}
+
+func TestAuthorizationEndpointConsentWritesResponse(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := testServer(func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+		w.Write([]byte("sign in please"))
+		return nil, nil
+	})
+
+	w := httptest.NewRecorder()
+	s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(nil))
+
+	assert(w.Code).Equal(http.StatusOK)
+	assert(w.Body.String()).Equal("sign in please")
+	assert(s.grants).Len(0)
+}
+
+func TestAuthorizationEndpointConsentDenied(t *testing.T) {
+	testCases := map[string]struct {
+		err         error
+		code        string
+		description string
+	}{
+		"oauth error": {
+			err:         &indieauth.OAuthError{Code: indieauth.ErrAccessDenied, Description: "no thanks"},
+			code:        "access_denied",
+			description: "no thanks",
+		},
+		"other error": {
+			err:  errors.New("what"),
+			code: "server_error",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			s := testServer(func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+				return nil, tc.err
+			})
+
+			w := httptest.NewRecorder()
+			s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(nil))
+
+			assert(w.Code).Equal(http.StatusFound)
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			assert(err).Must.Nil()
+
+			query := location.Query()
+			assert(query.Get("error")).Equal(tc.code)
+			assert(query.Get("error_description")).Equal(tc.description)
+			assert(query.Get("state")).Equal("1234")
+		})
+	}
+}
+
+func TestAuthorizationEndpointInvalidApproval(t *testing.T) {
+	testCases := map[string]*Approval{
+		"missing me":          {Scopes: []string{"create"}},
+		"invalid me":          {Me: "https://me.example.com/#me", Scopes: []string{"create"}},
+		"scope not requested": {Me: "https://me.example.com/", Scopes: []string{"create", "delete"}},
+	}
+
+	for name, approval := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			s := testServer(func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+				return approval, nil
+			})
+
+			w := httptest.NewRecorder()
+			s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(nil))
+
+			assert(w.Code).Equal(http.StatusFound)
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			assert(err).Must.Nil()
+
+			query := location.Query()
+			assert(query.Get("error")).Equal("server_error")
+			assert(query.Get("state")).Equal("1234")
+			assert(query.Get("code")).Equal("")
+			assert(s.grants).Len(0)
+		})
+	}
+}
+
+func TestMetadataEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := testServer(approve)
+
+	w := httptest.NewRecorder()
+	s.MetadataEndpoint().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	var metadata indieauth.Metadata
+	assert(json.NewDecoder(w.Body).Decode(&metadata)).Must.Nil()
+	assert(metadata.Issuer).Equal("https://auth.example.com/")
+	assert(metadata.SupportsCodeChallengeMethod("S256")).True()
+	assert(metadata.AuthorizationResponseIssParameterSupported).True()
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"hawx.me/code/indieauth/v2"
+)
+
+// introspectionResponse is the body returned for a token, as in
+// https://tools.ietf.org/html/rfc7662#section-2.2.
+type introspectionResponse struct {
+	Active   bool   `json:"active"`
+	Me       string `json:"me,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+	Iat      int64  `json:"iat,omitempty"`
+}
+
+func newIntrospectionResponse(introspection *indieauth.Introspection) introspectionResponse {
+	if !introspection.Active {
+		return introspectionResponse{}
+	}
+
+	response := introspectionResponse{
+		Active:   true,
+		Me:       introspection.Me,
+		ClientID: introspection.ClientID,
+		Scope:    strings.Join(introspection.Scopes, " "),
+	}
+	if !introspection.Expiry.IsZero() {
+		response.Exp = introspection.Expiry.Unix()
+	}
+	if !introspection.IssuedAt.IsZero() {
+		response.Iat = introspection.IssuedAt.Unix()
+	}
+
+	return response
+}
+
+// IntrospectionEndpoint returns the handler for the introspection endpoint, as
+// used by indieauth.Config.Introspect. Requests must use IntrospectionToken as a
+// Bearer token, so if it is not set every request is refused.
+func (s *Server) IntrospectionEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, &indieauth.OAuthError{Code: indieauth.ErrInvalidRequest, Description: "method must be POST"})
+			return
+		}
+
+		expected := []byte("Bearer " + s.IntrospectionToken)
+		if s.IntrospectionToken == "" ||
+			subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, errorResponse(&indieauth.OAuthError{Code: indieauth.ErrInvalidToken}))
+			return
+		}
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			writeError(w, &indieauth.OAuthError{Code: indieauth.ErrInvalidRequest, Description: "missing token"})
+			return
+		}
+
+		introspection, err := s.Introspect(token)
+		if err != nil {
+			writeError(w, &indieauth.OAuthError{Code: indieauth.ErrServerError})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newIntrospectionResponse(introspection))
+	}
+}
+
+// verifyToken answers a GET request to the token endpoint, by returning the
+// information for the Bearer token of the request or a 401 if it is not valid.
+func (s *Server) verifyToken(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) <= 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeJSON(w, http.StatusUnauthorized, errorResponse(&indieauth.OAuthError{Code: indieauth.ErrInvalidRequest}))
+		return
+	}
+
+	introspection, err := s.Introspect(strings.TrimSpace(auth[7:]))
+	if err != nil {
+		writeError(w, &indieauth.OAuthError{Code: indieauth.ErrServerError})
+		return
+	}
+
+	if !introspection.Active {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		writeJSON(w, http.StatusUnauthorized, errorResponse(&indieauth.OAuthError{Code: indieauth.ErrInvalidToken}))
+		return
+	}
+
+	// The legacy response has no "active" property.
+	writeJSON(w, http.StatusOK, struct {
+		Me       string `json:"me"`
+		ClientID string `json:"client_id"`
+		Scope    string `json:"scope"`
+	}{introspection.Me, introspection.ClientID, strings.Join(introspection.Scopes, " ")})
+}
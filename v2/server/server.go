@@ -0,0 +1,133 @@
+// Package server implements the endpoints of an IndieAuth server, so that users
+// can sign in to clients, such as those using the indieauth package, with their
+// own profile URL.
+package server
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"hawx.me/code/indieauth/v2"
+)
+
+// defaultCodeLifetime is how long an authorization code can be redeemed for if
+// Server.CodeLifetime is not set.
+const defaultCodeLifetime = time.Minute
+
+// defaultTokenLifetime is how long an access token is valid for if
+// Server.TokenLifetime is not set.
+const defaultTokenLifetime = 24 * time.Hour
+
+// Server issues authorization codes, and redeems them.
+type Server struct {
+	// CodeLifetime is how long an authorization code can be redeemed for after
+	// it has been issued. If zero, one minute is used.
+	CodeLifetime time.Duration
+
+	// TokenLifetime is how long an access token is valid for after it has been
+	// issued. If zero, one day is used.
+	TokenLifetime time.Duration
+
+	// Tokens stores the access tokens that are issued. New sets it to a
+	// MemoryTokenStore.
+	Tokens TokenStore
+
+	// IntrospectionToken must be sent as a Bearer token with requests to the
+	// IntrospectionEndpoint. If it is not set the IntrospectionEndpoint refuses
+	// all requests.
+	IntrospectionToken string
+
+	metadata indieauth.Metadata
+	consent  ConsentFunc
+
+	mu     sync.Mutex
+	grants map[string]*grant
+}
+
+// grant is what was approved for an authorization code.
+type grant struct {
+	clientID      string
+	redirectURI   string
+	codeChallenge string
+	me            string
+	scopes        []string
+	profile       *indieauth.Profile
+	expiry        time.Time
+}
+
+// New creates a Server described by metadata, which must at least have the
+// Issuer, AuthorizationEndpoint and TokenEndpoint set. If IntrospectionEndpoint
+// is set it should be where the IntrospectionEndpoint handler is served, and
+// IntrospectionToken must then be set for it to answer requests. The response
+// types, grant types and code challenge methods the Server supports are set on
+// the metadata. Valid authorization requests are passed to consent.
+func New(metadata indieauth.Metadata, consent ConsentFunc) *Server {
+	metadata.ResponseTypesSupported = []string{"code"}
+	metadata.GrantTypesSupported = []string{"authorization_code"}
+	metadata.CodeChallengeMethodsSupported = []string{"S256"}
+	metadata.AuthorizationResponseIssParameterSupported = true
+
+	return &Server{
+		metadata: metadata,
+		consent:  consent,
+		grants:   map[string]*grant{},
+		Tokens:   NewMemoryTokenStore(),
+	}
+}
+
+// Metadata returns the metadata describing the Server.
+func (s *Server) Metadata() indieauth.Metadata {
+	return s.metadata
+}
+
+// MetadataEndpoint returns a handler that responds with the metadata document
+// for the Server. Profile pages should link to it with
+// rel="indieauth-metadata".
+func (s *Server) MetadataEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(s.metadata)
+	}
+}
+
+// issue stores g and returns a new authorization code for it.
+func (s *Server) issue(g *grant) (string, error) {
+	code, err := randomString()
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := s.CodeLifetime
+	if lifetime <= 0 {
+		lifetime = defaultCodeLifetime
+	}
+
+	now := time.Now()
+	g.expiry = now.Add(lifetime)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, existing := range s.grants {
+		if !now.Before(existing.expiry) {
+			delete(s.grants, key)
+		}
+	}
+
+	s.grants[code] = g
+	return code, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(b), "="), nil
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"hawx.me/code/indieauth/v2"
+)
+
+// A TokenStore keeps the access tokens issued by a Server, so that they can be
+// checked later.
+type TokenStore interface {
+	// Save stores introspection for token.
+	Save(token string, introspection *indieauth.Introspection) error
+
+	// Get returns what was stored for token, or nil if nothing was.
+	Get(token string) (*indieauth.Introspection, error)
+}
+
+// MemoryTokenStore is a TokenStore that keeps tokens in memory, so they are lost
+// when the process exits. Expired tokens are removed.
+type MemoryTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*indieauth.Introspection
+}
+
+// NewMemoryTokenStore returns an empty MemoryTokenStore.
+func NewMemoryTokenStore() *MemoryTokenStore {
+	return &MemoryTokenStore{tokens: map[string]*indieauth.Introspection{}}
+}
+
+// Save stores introspection for token, and removes any tokens that have
+// expired.
+func (s *MemoryTokenStore) Save(token string, introspection *indieauth.Introspection) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, existing := range s.tokens {
+		if expired(existing, now) {
+			delete(s.tokens, key)
+		}
+	}
+
+	s.tokens[token] = introspection
+	return nil
+}
+
+// Get returns what was stored for token, or nil if nothing was or it has
+// expired.
+func (s *MemoryTokenStore) Get(token string) (*indieauth.Introspection, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	introspection, ok := s.tokens[token]
+	if !ok {
+		return nil, nil
+	}
+
+	if expired(introspection, time.Now()) {
+		delete(s.tokens, token)
+		return nil, nil
+	}
+
+	return introspection, nil
+}
+
+func expired(introspection *indieauth.Introspection, now time.Time) bool {
+	return !introspection.Expiry.IsZero() && !now.Before(introspection.Expiry)
+}
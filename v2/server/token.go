@@ -0,0 +1,223 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"hawx.me/code/indieauth/v2"
+)
+
+// tokenResponse is the body returned when a code is redeemed, it matches what
+// indieauth.Config.Exchange expects.
+type tokenResponse struct {
+	AccessToken string             `json:"access_token,omitempty"`
+	TokenType   string             `json:"token_type,omitempty"`
+	Scope       string             `json:"scope,omitempty"`
+	ExpiresIn   int64              `json:"expires_in,omitempty"`
+	Me          string             `json:"me"`
+	Profile     *indieauth.Profile `json:"profile,omitempty"`
+}
+
+// TokenEndpoint returns the handler for the token endpoint. It redeems
+// authorization codes for an access token with the approved scopes. If only
+// the "profile" or "email" scopes were approved then no access token is issued,
+// and only the profile URL and profile information are returned.
+//
+// A GET request with an access token as a Bearer token is answered with the
+// information for the token, as resource servers that don't use an
+// introspection endpoint expect.
+func (s *Server) TokenEndpoint() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			s.verifyToken(w, r)
+			return
+		}
+
+		g, err := s.redeem(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		response := g.response()
+		if !isProfile(g.scopes) {
+			token, lifetime, err := s.issueToken(g)
+			if err != nil {
+				writeError(w, &indieauth.OAuthError{Code: indieauth.ErrServerError})
+				return
+			}
+
+			response.AccessToken = token
+			response.TokenType = "Bearer"
+			response.Scope = strings.Join(g.scopes, " ")
+			response.ExpiresIn = int64(lifetime / time.Second)
+		}
+
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+// redeemProfile redeems an authorization code sent to the authorization
+// endpoint, which only returns the profile URL and profile information.
+func (s *Server) redeemProfile(w http.ResponseWriter, r *http.Request) {
+	g, err := s.redeem(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, g.response())
+}
+
+// redeem checks the code redemption request r and returns the grant for its
+// code. A code can only be redeemed once, even if the request is not valid.
+func (s *Server) redeem(r *http.Request) (*grant, *indieauth.OAuthError) {
+	if r.Method != http.MethodPost {
+		return nil, &indieauth.OAuthError{Code: indieauth.ErrInvalidRequest, Description: "method must be POST"}
+	}
+
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		return nil, &indieauth.OAuthError{Code: indieauth.ErrUnsupportedGrantType}
+	}
+
+	for _, key := range []string{"code", "client_id", "redirect_uri", "code_verifier"} {
+		if r.PostFormValue(key) == "" {
+			return nil, &indieauth.OAuthError{Code: indieauth.ErrInvalidRequest, Description: "missing " + key}
+		}
+	}
+
+	s.mu.Lock()
+	g, ok := s.grants[r.PostFormValue("code")]
+	delete(s.grants, r.PostFormValue("code"))
+	s.mu.Unlock()
+
+	if !ok || !time.Now().Before(g.expiry) {
+		return nil, &indieauth.OAuthError{Code: indieauth.ErrInvalidGrant, Description: "code is not valid"}
+	}
+
+	if r.PostFormValue("client_id") != g.clientID || r.PostFormValue("redirect_uri") != g.redirectURI {
+		return nil, &indieauth.OAuthError{Code: indieauth.ErrInvalidGrant, Description: "code was not issued for this client"}
+	}
+
+	if !verifyChallenge(r.PostFormValue("code_verifier"), g.codeChallenge) {
+		return nil, &indieauth.OAuthError{Code: indieauth.ErrInvalidGrant, Description: "code_verifier does not match"}
+	}
+
+	return g, nil
+}
+
+// response returns the profile URL, and the profile information if the
+// "profile" scope was approved.
+func (g *grant) response() tokenResponse {
+	response := tokenResponse{Me: g.me}
+
+	if g.profile != nil && contains(g.scopes, "profile") {
+		profile := *g.profile
+		if !contains(g.scopes, "email") {
+			profile.Email = ""
+		}
+		response.Profile = &profile
+	}
+
+	return response
+}
+
+// Introspect returns information about an access token issued by the Server.
+// If the token was not issued by the Server, or has expired, an inactive
+// Introspection is returned.
+func (s *Server) Introspect(token string) (*indieauth.Introspection, error) {
+	introspection, err := s.Tokens.Get(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if introspection == nil || expired(introspection, time.Now()) {
+		return &indieauth.Introspection{}, nil
+	}
+
+	result := *introspection
+	return &result, nil
+}
+
+// issueToken creates an access token for g, returning it and its lifetime.
+func (s *Server) issueToken(g *grant) (string, time.Duration, error) {
+	token, err := randomString()
+	if err != nil {
+		return "", 0, err
+	}
+
+	lifetime := s.TokenLifetime
+	if lifetime <= 0 {
+		lifetime = defaultTokenLifetime
+	}
+
+	now := time.Now()
+	err = s.Tokens.Save(token, &indieauth.Introspection{
+		Active:   true,
+		Me:       g.me,
+		ClientID: g.clientID,
+		Scopes:   g.scopes,
+		Expiry:   now.Add(lifetime),
+		IssuedAt: now,
+	})
+
+	return token, lifetime, err
+}
+
+func verifyChallenge(verifier, challenge string) bool {
+	sum := sha256.Sum256([]byte(verifier))
+	expected := strings.TrimRight(base64.URLEncoding.EncodeToString(sum[:]), "=")
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+// isProfile returns true if scopes only allow access to the profile.
+func isProfile(scopes []string) bool {
+	for _, scope := range scopes {
+		if scope != "profile" && scope != "email" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, candidate := range list {
+		if candidate == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeError responds with err as described in
+// https://tools.ietf.org/html/rfc6749#section-5.2.
+func writeError(w http.ResponseWriter, err *indieauth.OAuthError) {
+	status := http.StatusBadRequest
+	if err.Code == indieauth.ErrServerError {
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, errorResponse(err))
+}
+
+func errorResponse(err *indieauth.OAuthError) interface{} {
+	return struct {
+		Error       indieauth.ErrorCode `json:"error"`
+		Description string              `json:"error_description,omitempty"`
+	}{err.Code, err.Description}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
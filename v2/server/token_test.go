@@ -0,0 +1,403 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+	"hawx.me/code/indieauth/v2"
+)
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(sum[:]), "=")
+}
+
+// authorize runs the authorization flow against s and returns the code.
+func authorize(t *testing.T, s *Server, params url.Values) string {
+	w := httptest.NewRecorder()
+	s.AuthorizationEndpoint().ServeHTTP(w, authorizationRequest(params))
+
+	location, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location.Query().Get("code")
+}
+
+func redeemRequest(params url.Values) *http.Request {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"client_id":     {"https://app.example.com/"},
+		"redirect_uri":  {"https://app.example.com/callback"},
+		"code_verifier": {"verifier"},
+	}
+	for key, values := range params {
+		form[key] = values
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestTokenEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := testServer(approve)
+	s.TokenLifetime = time.Hour
+
+	code := authorize(t, s, url.Values{"code_challenge": {s256("verifier")}})
+
+	w := httptest.NewRecorder()
+	s.TokenEndpoint().ServeHTTP(w, redeemRequest(url.Values{"code": {code}}))
+
+	assert(w.Code).Equal(http.StatusOK)
+	assert(w.Header().Get("Cache-Control")).Equal("no-store")
+
+	var response tokenResponse
+	assert(json.NewDecoder(w.Body).Decode(&response)).Must.Nil()
+	assert(response.Me).Equal("https://me.example.com/")
+	assert(response.TokenType).Equal("Bearer")
+	assert(response.Scope).Equal("create update")
+	assert(response.ExpiresIn).Equal(int64(3600))
+	assert(response.AccessToken).NotEqual("")
+
+	introspection, err := s.Introspect(response.AccessToken)
+	assert(err).Must.Nil()
+	assert(introspection.Active).True()
+	assert(introspection.Me).Equal("https://me.example.com/")
+	assert(introspection.ClientID).Equal("https://app.example.com/")
+	assert(introspection.Scopes).Equal([]string{"create", "update"})
+
+	introspection, err = s.Introspect("what")
+	assert(err).Must.Nil()
+	assert(introspection.Active).False()
+}
+
+func TestTokenEndpointProfileOnly(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := testServer(func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+		return &Approval{
+			Me:      "https://me.example.com/",
+			Scopes:  req.Scopes,
+			Profile: &indieauth.Profile{Name: "John", Email: "john@example.com"},
+		}, nil
+	})
+
+	code := authorize(t, s, url.Values{
+		"code_challenge": {s256("verifier")},
+		"scope":          {"profile"},
+	})
+
+	w := httptest.NewRecorder()
+	s.TokenEndpoint().ServeHTTP(w, redeemRequest(url.Values{"code": {code}}))
+
+	assert(w.Code).Equal(http.StatusOK)
+
+	var response map[string]interface{}
+	assert(json.NewDecoder(w.Body).Decode(&response)).Must.Nil()
+	assert(response).Equal(map[string]interface{}{
+		"me":      "https://me.example.com/",
+		"profile": map[string]interface{}{"name": "John"},
+	})
+}
+
+func TestTokenEndpointInvalidRequests(t *testing.T) {
+	testCases := map[string]struct {
+		params url.Values
+		err    string
+	}{
+		"grant_type":    {url.Values{"grant_type": {"password"}}, "unsupported_grant_type"},
+		"missing code":  {url.Values{"code": {""}}, "invalid_request"},
+		"unknown code":  {url.Values{"code": {"what"}}, "invalid_grant"},
+		"client_id":     {url.Values{"client_id": {"https://evil.example.com/"}}, "invalid_grant"},
+		"redirect_uri":  {url.Values{"redirect_uri": {"https://app.example.com/other"}}, "invalid_grant"},
+		"code_verifier": {url.Values{"code_verifier": {"other"}}, "invalid_grant"},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			s := testServer(approve)
+			code := authorize(t, s, url.Values{"code_challenge": {s256("verifier")}})
+
+			params := url.Values{"code": {code}}
+			for key, values := range tc.params {
+				params[key] = values
+			}
+
+			w := httptest.NewRecorder()
+			s.TokenEndpoint().ServeHTTP(w, redeemRequest(params))
+
+			assert(w.Code).Equal(http.StatusBadRequest)
+
+			var response map[string]string
+			assert(json.NewDecoder(w.Body).Decode(&response)).Must.Nil()
+			assert(response["error"]).Equal(tc.err)
+		})
+	}
+}
+
+func TestTokenEndpointCodeIsSingleUse(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := testServer(approve)
+	code := authorize(t, s, url.Values{"code_challenge": {s256("verifier")}})
+
+	w := httptest.NewRecorder()
+	s.TokenEndpoint().ServeHTTP(w, redeemRequest(url.Values{"code": {code}}))
+	assert(w.Code).Equal(http.StatusOK)
+
+	w = httptest.NewRecorder()
+	s.TokenEndpoint().ServeHTTP(w, redeemRequest(url.Values{"code": {code}}))
+	assert(w.Code).Equal(http.StatusBadRequest)
+}
+
+func TestTokenEndpointCodeExpires(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	s := testServer(approve)
+	s.CodeLifetime = time.Millisecond
+
+	code := authorize(t, s, url.Values{"code_challenge": {s256("verifier")}})
+	time.Sleep(5 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	s.TokenEndpoint().ServeHTTP(w, redeemRequest(url.Values{"code": {code}}))
+	assert(w.Code).Equal(http.StatusBadRequest)
+}
+
+// testIndieAuth starts a Server, and a profile page for a user of the Server,
+// so that they can be used with indieauth.Config.
+func testIndieAuth(profile *indieauth.Profile) (me *httptest.Server, s *Server, close func()) {
+	mux := http.NewServeMux()
+	auth := httptest.NewServer(mux)
+
+	me = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="indieauth-metadata" href="%s/metadata" />`, auth.URL)
+	}))
+
+	s = New(indieauth.Metadata{
+		Issuer:                auth.URL + "/",
+		AuthorizationEndpoint: auth.URL + "/auth",
+		TokenEndpoint:         auth.URL + "/token",
+		IntrospectionEndpoint: auth.URL + "/introspect",
+	}, func(w http.ResponseWriter, r *http.Request, req *AuthorizationRequest) (*Approval, error) {
+		return &Approval{Me: me.URL + "/", Scopes: req.Scopes, Profile: profile}, nil
+	})
+	s.IntrospectionToken = "resource"
+
+	mux.Handle("/metadata", s.MetadataEndpoint())
+	mux.Handle("/auth", s.AuthorizationEndpoint())
+	mux.Handle("/token", s.TokenEndpoint())
+	mux.Handle("/introspect", s.IntrospectionEndpoint())
+
+	return me, s, func() {
+		me.Close()
+		auth.Close()
+	}
+}
+
+// signIn follows the flow a client would, returning the result of exchanging
+// the code.
+func signIn(t *testing.T, config *indieauth.Config, me string) (*indieauth.Response, error) {
+	endpoints, err := config.FindEndpoints(me)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authURL := config.AuthCodeURL(endpoints, "state", s256("verifier"), me)
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := location.Query()
+	if query.Get("state") != "state" {
+		t.Fatal("unexpected state")
+	}
+	if err := endpoints.VerifyIssuer(query.Get("iss")); err != nil {
+		t.Fatal(err)
+	}
+
+	return config.Exchange(endpoints, "verifier", query.Get("code"))
+}
+
+func TestEndToEnd(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me, s, close := testIndieAuth(nil)
+	defer close()
+
+	response, err := signIn(t, &indieauth.Config{
+		ClientID:    "https://app.example.com/",
+		RedirectURL: "https://app.example.com/callback",
+		Scopes:      []string{"create"},
+	}, me.URL)
+	assert(err).Must.Nil()
+
+	assert(response.Me).Equal(me.URL + "/")
+	assert(response.Scopes).Equal([]string{"create"})
+	assert(response.AccessToken).NotEqual("")
+
+	introspection, err := s.Introspect(response.AccessToken)
+	assert(err).Must.Nil()
+	assert(introspection.Active).True()
+	assert(introspection.Me).Equal(me.URL + "/")
+}
+
+func TestEndToEndTokenVerification(t *testing.T) {
+	me, _, close := testIndieAuth(nil)
+	defer close()
+
+	config := &indieauth.Config{
+		ClientID:           "https://app.example.com/",
+		RedirectURL:        "https://app.example.com/callback",
+		Scopes:             []string{"create"},
+		IntrospectionToken: "resource",
+	}
+
+	response, err := signIn(t, config, me.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	endpoints, err := config.FindEndpoints(me.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := map[string]indieauth.Endpoints{
+		"introspection": endpoints,
+		"legacy":        {Token: endpoints.Token},
+	}
+
+	for name, endpoints := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			verifier := indieauth.NewTokenVerifier(config, endpoints, 0)
+
+			verified, err := verifier.Verify(context.Background(), response.AccessToken)
+			assert(err).Must.Nil()
+			assert(verified.Me).Equal(me.URL + "/")
+			assert(verified.Scopes).Equal([]string{"create"})
+
+			_, err = verifier.Verify(context.Background(), "what")
+			assert(errors.Is(err, indieauth.ErrInvalidToken)).True()
+		})
+	}
+}
+
+func TestIntrospectionEndpointRequiresToken(t *testing.T) {
+	testCases := map[string]struct {
+		introspectionToken string
+		authorization      string
+	}{
+		"wrong token":               {introspectionToken: "resource", authorization: "Bearer wrong"},
+		"missing token":             {introspectionToken: "resource"},
+		"not configured":            {authorization: "Bearer "},
+		"not configured, none sent": {},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			s := testServer(approve)
+			s.IntrospectionToken = tc.introspectionToken
+
+			r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader("token=what"))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tc.authorization != "" {
+				r.Header.Set("Authorization", tc.authorization)
+			}
+
+			w := httptest.NewRecorder()
+			s.IntrospectionEndpoint().ServeHTTP(w, r)
+
+			assert(w.Code).Equal(http.StatusUnauthorized)
+		})
+	}
+}
+
+func TestMemoryTokenStoreRemovesExpiredTokens(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	store := NewMemoryTokenStore()
+	store.Save("old", &indieauth.Introspection{Active: true, Expiry: time.Now().Add(-time.Second)})
+	store.Save("new", &indieauth.Introspection{Active: true, Expiry: time.Now().Add(time.Hour)})
+
+	assert(store.tokens).Len(1)
+
+	introspection, err := store.Get("new")
+	assert(err).Must.Nil()
+	assert(introspection.Active).True()
+}
+
+func TestEndToEndProfile(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me, _, close := testIndieAuth(&indieauth.Profile{Name: "John", Email: "john@example.com"})
+	defer close()
+
+	response, err := signIn(t, &indieauth.Config{
+		ClientID:    "https://app.example.com/",
+		RedirectURL: "https://app.example.com/callback",
+		Scopes:      []string{"profile"},
+	}, me.URL)
+	assert(err).Must.Nil()
+
+	assert(response.Me).Equal(me.URL + "/")
+	assert(response.AccessToken).Equal("")
+	assert(response.Profile).Equal(&indieauth.Profile{Name: "John"})
+}
+
+func TestEndToEndInvalidVerifier(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me, s, close := testIndieAuth(nil)
+	defer close()
+
+	config := &indieauth.Config{
+		ClientID:    "https://app.example.com/",
+		RedirectURL: "https://app.example.com/callback",
+		Scopes:      []string{"create"},
+	}
+
+	endpoints, err := config.FindEndpoints(me.URL)
+	assert(err).Must.Nil()
+
+	code := authorize(t, s, url.Values{"code_challenge": {s256("verifier")}})
+
+	_, err = config.Exchange(endpoints, "wrong", code)
+	assert(errors.Is(err, indieauth.ErrInvalidGrant)).True()
+}
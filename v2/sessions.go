@@ -7,6 +7,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gorilla/sessions"
@@ -16,14 +17,24 @@ type sessionData struct {
 	State     string
 	Verifier  string
 	Endpoints Endpoints
+	Issuer    string
 }
 
 func init() {
 	gob.Register(sessionData{})
 	gob.Register(Response{})
+	gob.Register(Endpoints{})
+
+	// Allow nested values in Profile.Extra to be stored.
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
 }
 
 type Sessions struct {
+	// RevokeOnSignOut, when true, makes SignOut revoke the tokens of the
+	// current user.
+	RevokeOnSignOut bool
+
 	store  sessions.Store
 	config *Config
 }
@@ -43,9 +54,20 @@ func NewSessions(secret string, config *Config) (*Sessions, error) {
 }
 
 // RedirectToSignIn will issue a redirect to the authorization endpoint
-// discovered for "me".
-func (s *Sessions) RedirectToSignIn(w http.ResponseWriter, r *http.Request, me string) error {
-	endpoints, err := s.config.FindEndpoints(me)
+// discovered for "me". If "me" is not a valid profile URL a *ProfileURLError is
+// returned. Any opts are added to the redirect, if one would overwrite a
+// parameter required for the flow a *ReservedParameterError is returned. The
+// context of r is used for any requests made.
+func (s *Sessions) RedirectToSignIn(w http.ResponseWriter, r *http.Request, me string, opts ...AuthCodeOption) error {
+	if _, err := ParseProfileURL(me); err != nil {
+		return err
+	}
+
+	if err := CheckAuthCodeOptions(opts...); err != nil {
+		return err
+	}
+
+	endpoints, err := s.config.FindEndpointsContext(r.Context(), me)
 	if err != nil {
 		return fmt.Errorf("could not find authorization endpoints: %w", err)
 	}
@@ -60,19 +82,23 @@ func (s *Sessions) RedirectToSignIn(w http.ResponseWriter, r *http.Request, me s
 		return err
 	}
 
+	// Only keep the endpoints needed to finish signing in, and to sign out, as
+	// the session is stored in a cookie which has a limited size.
 	err = s.setData(w, r, sessionData{
-		State:     state,
-		Verifier:  verifier,
-		Endpoints: endpoints,
+		State:    state,
+		Verifier: verifier,
+		Endpoints: Endpoints{
+			Authorization: endpoints.Authorization,
+			Token:         endpoints.Token,
+			Revocation:    endpoints.Revocation,
+		},
+		Issuer: endpoints.Issuer(),
 	})
 	if err != nil {
 		return err
 	}
 
-	redirectURL := s.config.AuthCodeURL(endpoints, state, s256(verifier), me)
-	if err != nil {
-		return fmt.Errorf("could not build redirect url: %w", err)
-	}
+	redirectURL := s.config.AuthCodeURL(endpoints, state, s256(verifier), endpoints.Me, opts...)
 
 	http.Redirect(w, r, redirectURL, http.StatusFound)
 	return nil
@@ -80,25 +106,86 @@ func (s *Sessions) RedirectToSignIn(w http.ResponseWriter, r *http.Request, me s
 
 // Verify will complete the authentication process and should be called
 // in the route assigned to RedirectURL. After calling this, redirect to another
-// page of your application.
+// page of your application. The context of r is used for any requests made.
 func (s *Sessions) Verify(w http.ResponseWriter, r *http.Request) error {
 	data := s.getData(r)
 
+	if data.State == "" {
+		return ErrFlowExpired
+	}
+
 	if r.FormValue("state") != data.State {
-		return fmt.Errorf("unexpected state")
+		return ErrStateMismatch
 	}
 
-	response, err := s.config.Exchange(data.Endpoints, data.Verifier, r.FormValue("code"))
+	if err := verifyIssuer(data.Issuer, r.FormValue("iss")); err != nil {
+		return err
+	}
+
+	if code := r.FormValue("error"); code != "" {
+		return &OAuthError{
+			Code:        ErrorCode(code),
+			Description: r.FormValue("error_description"),
+			URI:         r.FormValue("error_uri"),
+		}
+	}
+
+	code := r.FormValue("code")
+	if code == "" {
+		return ErrCodeMissing
+	}
+
+	response, err := s.config.ExchangeContext(r.Context(), data.Endpoints, data.Verifier, code)
 	if err != nil {
 		return fmt.Errorf("code exchange failed: %w", err)
 	}
 
-	return s.set(w, r, response)
+	return s.set(w, r, response, data.Endpoints)
 }
 
-// SignOut will remove the session cookie for the user.
+// SignOut will remove the session cookie for the user. If RevokeOnSignOut is
+// set any tokens for the user are revoked, an error revoking will be returned
+// after the session cookie has been removed.
 func (s *Sessions) SignOut(w http.ResponseWriter, r *http.Request) error {
-	return s.set(w, r, &Response{})
+	var revokeErr error
+	if s.RevokeOnSignOut {
+		revokeErr = s.revoke(r)
+	}
+
+	if err := s.set(w, r, &Response{}, Endpoints{}); err != nil {
+		return err
+	}
+
+	if revokeErr != nil {
+		return fmt.Errorf("token revocation failed: %w", revokeErr)
+	}
+
+	return nil
+}
+
+func (s *Sessions) revoke(r *http.Request) error {
+	session, _ := s.store.Get(r, "session")
+	response, _ := session.Values["response"].(Response)
+
+	var endpoints Endpoints
+	if token, _ := session.Values["token_endpoint"].(string); token != "" {
+		endpoints.Token, _ = url.Parse(token)
+	}
+	if revocation, _ := session.Values["revocation_endpoint"].(string); revocation != "" {
+		endpoints.Revocation, _ = url.Parse(revocation)
+	}
+
+	for _, token := range []string{response.RefreshToken, response.AccessToken} {
+		if token == "" {
+			continue
+		}
+
+		if err := s.config.RevokeContext(r.Context(), endpoints, token); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 // SignedIn will return the response for the current session, if signed in.
@@ -107,6 +194,19 @@ func (s *Sessions) SignedIn(r *http.Request) (*Response, bool) {
 	return response, response != nil && response.Me != ""
 }
 
+// Handler returns a handler that stores the Response for the current session,
+// if signed in, in the request context before calling next. It can be retrieved
+// with ResponseFromContext, or checked with RequireScope.
+func (s *Sessions) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if response, ok := s.SignedIn(r); ok {
+			r = r.WithContext(ContextWithResponse(r.Context(), response))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (s *Sessions) get(r *http.Request) *Response {
 	session, _ := s.store.Get(r, "session")
 	response, _ := session.Values["response"].(Response)
@@ -114,11 +214,20 @@ func (s *Sessions) get(r *http.Request) *Response {
 	return &response
 }
 
-func (s *Sessions) set(w http.ResponseWriter, r *http.Request, response *Response) error {
+// set stores response for the session, along with the endpoints needed to
+// revoke its tokens. The endpoints are stored as strings to keep the cookie
+// small.
+func (s *Sessions) set(w http.ResponseWriter, r *http.Request, response *Response, endpoints Endpoints) error {
 	session, _ := s.store.Get(r, "session")
 	session.Values = map[interface{}]interface{}{
 		"response": response,
 	}
+	if endpoints.Token != nil {
+		session.Values["token_endpoint"] = endpoints.Token.String()
+	}
+	if endpoints.Revocation != nil {
+		session.Values["revocation_endpoint"] = endpoints.Revocation.String()
+	}
 
 	return session.Save(r, w)
 }
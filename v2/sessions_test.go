@@ -1,9 +1,12 @@
 package indieauth
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
 
@@ -46,6 +49,165 @@ func TestSessionsRedirectToSignIn(t *testing.T) {
 	assert(resp.Header.Get("Location")).Equal(expectedRedirect)
 }
 
+// fullMetadataDocument is a metadata document that defines every property, so
+// can be used to check that discovered endpoints fit in a session cookie.
+const fullMetadataDocument = `{
+	"issuer": "https://auth.example.com/",
+	"authorization_endpoint": "https://auth.example.com/auth",
+	"token_endpoint": "https://auth.example.com/token",
+	"introspection_endpoint": "https://auth.example.com/introspect",
+	"introspection_endpoint_auth_methods_supported": ["Bearer", "client_secret_basic", "client_secret_post"],
+	"revocation_endpoint": "https://auth.example.com/revoke",
+	"revocation_endpoint_auth_methods_supported": ["none", "client_secret_basic", "client_secret_post"],
+	"userinfo_endpoint": "https://auth.example.com/userinfo",
+	"service_documentation": "https://auth.example.com/documentation/for/the/service",
+	"scopes_supported": ["profile", "email", "create", "update", "delete", "media", "draft", "read", "follow", "mute", "block", "channels"],
+	"response_types_supported": ["code"],
+	"grant_types_supported": ["authorization_code", "refresh_token"],
+	"code_challenge_methods_supported": ["S256"],
+	"authorization_response_iss_parameter_supported": true
+}`
+
+func TestSessionsRedirectToSignInWithFullMetadata(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	metadata := testIssuerMetadataEndpoint(fullMetadataDocument)
+	defer metadata.Close()
+
+	me := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="indieauth-metadata" href="%s" />
+<link rel="micropub" href="https://micropub.example.com/micropub" />
+<link rel="microsub" href="https://microsub.example.com/microsub" />
+<link rel="webmention" href="https://webmention.example.com/webmention" />`, metadata.URL)
+	}))
+	defer me.Close()
+
+	sessions, err := NewSessions("KA==", &Config{
+		ClientID:    "https://example.org/",
+		RedirectURL: "https://example.org/redirect",
+	})
+	assert(err).Must.Nil()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	err = sessions.RedirectToSignIn(w, r, me.URL)
+	assert(err).Must.Nil()
+
+	sess, _ := sessions.store.Get(r, "session")
+	data, ok := sess.Values["data"].(sessionData)
+	assert(ok).Must.True()
+	assert(data.Issuer).Equal(metadata.URL + "/")
+	assert(data.Endpoints.Authorization.String()).Equal(metadata.URL + "/auth")
+	assert(data.Endpoints.Token.String()).Equal(metadata.URL + "/token")
+	assert(data.Endpoints.Revocation.String()).Equal(metadata.URL + "/revoke")
+	assert(data.Endpoints.Metadata == nil).True()
+}
+
+func TestSessionsSignInWithFullMetadata(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	longToken := strings.Repeat("t", 64)
+
+	var me, auth *httptest.Server
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metadata", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, strings.NewReplacer("https://auth.example.com", auth.URL).Replace(fullMetadataDocument))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "%s", "refresh_token": "%s", "token_type": "Bearer", "scope": "create update", "expires_in": 3600, "me": "%s/"}`,
+			longToken, longToken, me.URL)
+	})
+	auth = httptest.NewServer(mux)
+	defer auth.Close()
+
+	me = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="indieauth-metadata" href="%s/metadata" />`, auth.URL)
+	}))
+	defer me.Close()
+
+	sessions, err := NewSessions("KA==", &Config{
+		ClientID:    "https://example.org/",
+		RedirectURL: "https://example.org/redirect",
+		Scopes:      []string{"create", "update"},
+	})
+	assert(err).Must.Nil()
+
+	w := httptest.NewRecorder()
+	err = sessions.RedirectToSignIn(w, httptest.NewRequest(http.MethodGet, "/", nil), me.URL)
+	assert(err).Must.Nil()
+
+	location := urlParse(w.Result().Header.Get("Location"))
+	callback := url.Values{
+		"state": {location.Query().Get("state")},
+		"code":  {"1234"},
+		"iss":   {auth.URL + "/"},
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/redirect?"+callback.Encode(), nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	w = httptest.NewRecorder()
+	err = sessions.Verify(w, r)
+	assert(err).Must.Nil()
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+
+	response, ok := sessions.SignedIn(r)
+	assert(ok).Must.True()
+	assert(response.Me).Equal(me.URL + "/")
+	assert(response.AccessToken).Equal(longToken)
+
+	sess, _ := sessions.store.Get(r, "session")
+	assert(sess.Values["revocation_endpoint"]).Equal(auth.URL + "/revoke")
+	assert(sess.Values["token_endpoint"]).Equal(auth.URL + "/token")
+}
+
+func TestSessionsRedirectToSignInWithOptions(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	me := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<link rel="authorization_endpoint" href="https://auth/" />`)
+	}))
+	defer me.Close()
+
+	sessions, err := NewSessions("KA==", &Config{})
+	assert(err).Must.Nil()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	err = sessions.RedirectToSignIn(w, r, me.URL, Prompt("login"))
+	assert(err).Must.Nil()
+
+	location := urlParse(w.Result().Header.Get("Location"))
+	assert(location.Query().Get("prompt")).Equal("login")
+}
+
+func TestSessionsRedirectToSignInWithReservedOption(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	sessions, err := NewSessions("KA==", &Config{})
+	assert(err).Must.Nil()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+
+	err = sessions.RedirectToSignIn(w, r, "https://me.example.com/", SetAuthURLParam("code_challenge_method", "plain"))
+	assert(err).Equal(&ReservedParameterError{Key: "code_challenge_method"})
+}
+
 func TestSessionsVerify(t *testing.T) {
 	assert := assert.Wrap(t)
 
@@ -86,3 +248,151 @@ func TestSessionsVerify(t *testing.T) {
 	err = sessions.Verify(w, r)
 	assert(err).Must.Nil()
 }
+
+func TestSessionsVerifyIssuer(t *testing.T) {
+	var me *httptest.Server
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"me": "%s"}`, me.URL)
+	}))
+	defer auth.Close()
+
+	me = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, `<link rel="authorization_endpoint" href="%s" />`, auth.URL)
+	}))
+	defer me.Close()
+
+	config := &Config{
+		ClientID:    "https://example.org/",
+		RedirectURL: "https://example.org/redirect",
+	}
+
+	testCases := map[string]struct {
+		body string
+		err  error
+	}{
+		"matching": {body: "state=abc&code=1234&iss=https%3A%2F%2Fauth.example.com%2F", err: nil},
+		"missing":  {body: "state=abc&code=1234", err: ErrIssuerMissing},
+		"mismatch": {body: "state=abc&code=1234&iss=https%3A%2F%2Fevil.example.com%2F", err: ErrIssuerMismatch},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			sessions, err := NewSessions("KA==", config)
+			assert(err).Must.Nil()
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			sessions.setData(w, r, sessionData{
+				Endpoints: Endpoints{
+					Authorization: urlParse(auth.URL),
+				},
+				Issuer:   "https://auth.example.com/",
+				State:    "abc",
+				Verifier: "verified",
+			})
+
+			err = sessions.Verify(w, r)
+			assert(err).Equal(tc.err)
+		})
+	}
+}
+
+func TestSessionsVerifyAuthorizationResponse(t *testing.T) {
+	config := &Config{
+		ClientID:    "https://example.org/",
+		RedirectURL: "https://example.org/redirect",
+	}
+
+	testCases := map[string]struct {
+		body string
+		data sessionData
+		err  error
+	}{
+		"no flow": {
+			body: "state=abc&code=1234",
+			err:  ErrFlowExpired,
+		},
+		"state mismatch": {
+			body: "state=xyz&code=1234",
+			data: sessionData{State: "abc"},
+			err:  ErrStateMismatch,
+		},
+		"state mismatch with error": {
+			body: "state=xyz&error=access_denied",
+			data: sessionData{State: "abc"},
+			err:  ErrStateMismatch,
+		},
+		"missing code": {
+			body: "state=abc",
+			data: sessionData{State: "abc"},
+			err:  ErrCodeMissing,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert := assert.Wrap(t)
+
+			sessions, err := NewSessions("KA==", config)
+			assert(err).Must.Nil()
+
+			w := httptest.NewRecorder()
+			r, _ := http.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body))
+			r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			if tc.data.State != "" {
+				sessions.setData(w, r, tc.data)
+			}
+
+			err = sessions.Verify(w, r)
+			assert(err).Equal(tc.err)
+		})
+	}
+}
+
+func TestSessionsVerifyAccessDenied(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	sessions, err := NewSessions("KA==", &Config{})
+	assert(err).Must.Nil()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/?state=abc&error=access_denied&error_description=User+said+no", nil)
+
+	sessions.setData(w, r, sessionData{State: "abc"})
+
+	err = sessions.Verify(w, r)
+	assert(errors.Is(err, ErrAccessDenied)).True()
+
+	var oauthErr *OAuthError
+	assert(errors.As(err, &oauthErr)).Must.True()
+	assert(oauthErr.Description).Equal("User said no")
+}
+
+func TestSessionsRedirectToSignInUsesRequestContext(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	me := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-ctx.Done()
+	}))
+	defer me.Close()
+
+	sessions, err := NewSessions("KA==", &Config{})
+	assert(err).Must.Nil()
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequestWithContext(ctx, http.MethodGet, "/", nil)
+
+	err = sessions.RedirectToSignIn(w, r, me.URL)
+	assert(errors.Is(err, context.Canceled)).True()
+}
@@ -0,0 +1,106 @@
+package indieauth
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// expiryDelta is how long before its expiry a token is refreshed, so that it
+// does not expire while a request is being made.
+const expiryDelta = 10 * time.Second
+
+// AuthClient returns an *http.Client that sets the access token of response as
+// a Bearer token on each request. If the token has a refresh token and is
+// about to expire it will be refreshed using ctx, then onRefresh, if not nil,
+// is called with the new Response so that it can be stored.
+//
+// The client is safe for concurrent use, a single refresh will be made even if
+// many requests are made at once.
+func (c *Config) AuthClient(ctx context.Context, endpoints Endpoints, response *Response, onRefresh func(*Response)) *http.Client {
+	base := c.client().Transport
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	return &http.Client{
+		Transport: &tokenTransport{
+			ctx:       ctx,
+			config:    c,
+			endpoints: endpoints,
+			response:  response,
+			onRefresh: onRefresh,
+			base:      base,
+		},
+	}
+}
+
+type tokenTransport struct {
+	ctx       context.Context
+	config    *Config
+	endpoints Endpoints
+	onRefresh func(*Response)
+	base      http.RoundTripper
+
+	mu       sync.Mutex
+	response *Response
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := t.token()
+	if err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+response.AccessToken)
+
+	return t.base.RoundTrip(req)
+}
+
+// token returns the current Response, refreshing it first if it is about to
+// expire.
+func (t *tokenTransport) token() (*Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.needsRefresh(time.Now()) {
+		return t.response, nil
+	}
+
+	refreshed, err := t.config.RefreshContext(t.ctx, t.endpoints, t.response.RefreshToken)
+	if err != nil {
+		if time.Now().Before(t.response.Expiry) {
+			return t.response, nil
+		}
+
+		return nil, err
+	}
+
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = t.response.RefreshToken
+	}
+	if refreshed.Me == "" {
+		refreshed.Me = t.response.Me
+	}
+	if len(refreshed.Scopes) == 0 {
+		refreshed.Scopes = t.response.Scopes
+	}
+
+	t.response = refreshed
+	if t.onRefresh != nil {
+		t.onRefresh(refreshed)
+	}
+
+	return refreshed, nil
+}
+
+func (t *tokenTransport) needsRefresh(now time.Time) bool {
+	return t.response.RefreshToken != "" &&
+		!t.response.Expiry.IsZero() &&
+		now.Add(expiryDelta).After(t.response.Expiry)
+}
@@ -0,0 +1,123 @@
+package indieauth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"hawx.me/code/assert"
+)
+
+func testResourceServer(token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "", http.StatusUnauthorized)
+		}
+	}))
+}
+
+func TestAuthClient(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	resource := testResourceServer("tokentoken")
+	defer resource.Close()
+
+	client := (&Config{}).AuthClient(context.Background(), Endpoints{}, &Response{
+		AccessToken: "tokentoken",
+	}, nil)
+
+	resp, err := client.Get(resource.URL)
+	assert(err).Must.Nil()
+	resp.Body.Close()
+
+	assert(resp.StatusCode).Equal(http.StatusOK)
+}
+
+func TestAuthClientRefreshes(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var refreshes int32
+
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&refreshes, 1)
+
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refreshrefresh" {
+			http.Error(w, "", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token": "newtoken", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer tokenEndpoint.Close()
+
+	resource := testResourceServer("newtoken")
+	defer resource.Close()
+
+	var mu sync.Mutex
+	var refreshed []*Response
+
+	client := (&Config{ClientID: "http://localhost"}).AuthClient(context.Background(), Endpoints{
+		Token: urlParse(tokenEndpoint.URL),
+	}, &Response{
+		AccessToken:  "oldtoken",
+		RefreshToken: "refreshrefresh",
+		Scopes:       []string{"create"},
+		Me:           "https://me.example.com/",
+		Expiry:       time.Now().Add(time.Second),
+	}, func(r *Response) {
+		mu.Lock()
+		refreshed = append(refreshed, r)
+		mu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resp, err := client.Get(resource.URL)
+			if assert(err).Nil() {
+				resp.Body.Close()
+				assert(resp.StatusCode).Equal(http.StatusOK)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert(atomic.LoadInt32(&refreshes)).Equal(int32(1))
+	assert(refreshed).Must.Len(1)
+	assert(refreshed[0].AccessToken).Equal("newtoken")
+	assert(refreshed[0].RefreshToken).Equal("refreshrefresh")
+	assert(refreshed[0].Scopes).Equal([]string{"create"})
+	assert(refreshed[0].Me).Equal("https://me.example.com/")
+}
+
+func TestAuthClientRefreshFailsAfterExpiry(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	tokenEndpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusBadRequest)
+	}))
+	defer tokenEndpoint.Close()
+
+	resource := testResourceServer("oldtoken")
+	defer resource.Close()
+
+	client := (&Config{}).AuthClient(context.Background(), Endpoints{
+		Token: urlParse(tokenEndpoint.URL),
+	}, &Response{
+		AccessToken:  "oldtoken",
+		RefreshToken: "refreshrefresh",
+		Expiry:       time.Now().Add(-time.Second),
+	}, nil)
+
+	_, err := client.Get(resource.URL)
+	assert(err).NotNil()
+}
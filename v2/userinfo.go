@@ -0,0 +1,95 @@
+package indieauth
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// Profile is the profile information returned for a user when the "profile"
+// scope is granted. Email will only be set when the "email" scope is also
+// granted.
+type Profile struct {
+	Name  string
+	URL   string
+	Photo string
+	Email string
+
+	// Extra contains any other properties that were returned.
+	Extra map[string]interface{}
+}
+
+var profileKeys = []string{"name", "url", "photo", "email"}
+
+func (p *Profile) fields() []*string {
+	return []*string{&p.Name, &p.URL, &p.Photo, &p.Email}
+}
+
+// UnmarshalJSON decodes a profile, keeping any unknown properties in Extra.
+func (p *Profile) UnmarshalJSON(data []byte) error {
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*p = Profile{}
+	fields := p.fields()
+
+	for i, key := range profileKeys {
+		if s, ok := v[key].(string); ok {
+			*fields[i] = s
+			delete(v, key)
+		}
+	}
+
+	if len(v) > 0 {
+		p.Extra = v
+	}
+
+	return nil
+}
+
+// MarshalJSON encodes the profile, including any properties in Extra.
+func (p Profile) MarshalJSON() ([]byte, error) {
+	v := map[string]interface{}{}
+	for key, value := range p.Extra {
+		v[key] = value
+	}
+
+	for i, field := range p.fields() {
+		if *field != "" {
+			v[profileKeys[i]] = *field
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// UserInfo fetches the current profile information for the user that
+// response was issued for, using the userinfo endpoint from the metadata
+// document. The access token must have been issued with the "profile" scope.
+func (c *Config) UserInfo(endpoints Endpoints, response *Response) (*Profile, error) {
+	return c.UserInfoContext(context.Background(), endpoints, response)
+}
+
+// UserInfoContext is like UserInfo but uses ctx for the requests made.
+func (c *Config) UserInfoContext(ctx context.Context, endpoints Endpoints, response *Response) (*Profile, error) {
+	if endpoints.Userinfo == nil {
+		return nil, ErrUserinfoEndpointMissing
+	}
+
+	ctx, cancel := withTimeout(ctx, c.ExchangeTimeout)
+	defer cancel()
+
+	resp, err := c.getWithToken(ctx, endpoints.Userinfo, response.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var profile Profile
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
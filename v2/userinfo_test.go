@@ -0,0 +1,90 @@
+package indieauth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"hawx.me/code/assert"
+)
+
+func TestProfileJSON(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	var profile Profile
+	err := json.Unmarshal([]byte(`{
+  "name": "John Doe",
+  "url": "https://john.example.com/",
+  "photo": "https://john.example.com/photo.jpg",
+  "email": "john@example.com",
+  "pronouns": "they/them",
+  "tags": ["a", "b"]
+}`), &profile)
+	assert(err).Must.Nil()
+
+	assert(profile.Name).Equal("John Doe")
+	assert(profile.URL).Equal("https://john.example.com/")
+	assert(profile.Photo).Equal("https://john.example.com/photo.jpg")
+	assert(profile.Email).Equal("john@example.com")
+	assert(profile.Extra).Equal(map[string]interface{}{
+		"pronouns": "they/them",
+		"tags":     []interface{}{"a", "b"},
+	})
+
+	data, err := json.Marshal(profile)
+	assert(err).Must.Nil()
+
+	var roundTrip Profile
+	assert(json.Unmarshal(data, &roundTrip)).Nil()
+	assert(roundTrip).Equal(profile)
+}
+
+func TestUserInfo(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tokentoken" {
+			http.Error(w, "", http.StatusUnauthorized)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"name": "John Doe", "url": "https://john.example.com/"}`)
+	}))
+	defer ts.Close()
+
+	profile, err := (&Config{}).UserInfo(Endpoints{Userinfo: urlParse(ts.URL)}, &Response{
+		AccessToken: "tokentoken",
+	})
+	assert(err).Must.Nil()
+
+	assert(profile.Name).Equal("John Doe")
+	assert(profile.URL).Equal("https://john.example.com/")
+	assert(profile.Extra).Nil()
+}
+
+func TestUserInfoUnauthorized(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "", http.StatusUnauthorized)
+	}))
+	defer ts.Close()
+
+	_, err := (&Config{}).UserInfo(Endpoints{Userinfo: urlParse(ts.URL)}, &Response{
+		AccessToken: "badtoken",
+	})
+
+	if requestErr, ok := err.(*RequestError); assert(ok).True() {
+		assert(requestErr.StatusCode).Equal(http.StatusUnauthorized)
+	}
+}
+
+func TestUserInfoWithoutEndpoint(t *testing.T) {
+	assert := assert.Wrap(t)
+
+	_, err := (&Config{}).UserInfo(Endpoints{}, &Response{})
+	assert(err).Equal(ErrUserinfoEndpointMissing)
+}